@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Manifest line for a cover stored in a -format zip archive
+type archiveEntry struct {
+	Code   string `json:"code"`
+	File   string `json:"file,omitempty"`
+	Status string `json:"status"`
+	URL    string `json:"url,omitempty"`
+	Hash   string `json:"sha256,omitempty"`
+}
+
+// Streams the fetched covers into a ZIP archive named by code, along with
+// manifest.json and, when some codes failed, failures.txt
+func writeCoverZip(filename string, items []coverItem) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+
+	entries := make([]archiveEntry, 0, len(items))
+	var failures strings.Builder
+	used := make(map[string]int)
+	for _, item := range items {
+		if item.Status == statusBlank {
+			continue
+		}
+		entry := archiveEntry{Code: item.Code, Status: item.Status, URL: item.URL, Hash: item.Hash}
+		if item.Status != statusOK {
+			fmt.Fprintf(&failures, "%s\t%s\n", item.Code, item.Status)
+			entries = append(entries, entry)
+			continue
+		}
+
+		// Repeated codes get a numeric suffix instead of a second entry with the same name
+		name := archiveName(item)
+		used[name]++
+		if n := used[name]; n > 1 {
+			ext := filepath.Ext(name)
+			name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), n, ext)
+		}
+		entry.File = name
+
+		if err := writeZipEntry(zw, name, item.Data); err != nil {
+			f.Close()
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := writeZipEntry(zw, "manifest.json", append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if failures.Len() > 0 {
+		if err := writeZipEntry(zw, "failures.txt", []byte(failures.String())); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// File name of a cover inside the archive; local files keep their own name
+func archiveName(item coverItem) string {
+	if filepath.Ext(item.Code) != "" {
+		return filepath.Base(item.Code)
+	}
+	if item.Format == "PNG" {
+		return item.Code + ".png"
+	}
+	return item.Code + ".jpg"
+}
+
+func writeZipEntry(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Stores downloaded images on disk, mirroring the host and path of their
+// URLs. Each distinct image is kept once under its content hash; the file
+// at a URL's path only refers to it, so codes sharing a cover share the bytes.
+type diskCache struct {
+	dir string
+}
+
+// Prefix of the small files that point a URL at a stored image
+const cacheRefPrefix = "sha256:"
+
+func newDiskCache(dir string) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: dir}, nil
+}
+
+func (c *diskCache) path(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	host := strings.ReplaceAll(u.Host, ":", "_")
+	clean := path.Clean("/" + u.Path)
+	return filepath.Join(c.dir, host, filepath.FromSlash(clean)), true
+}
+
+// Location of the image with the given content hash
+func (c *diskCache) objectPath(hash string) string {
+	return filepath.Join(c.dir, ".objects", hash[:2], hash)
+}
+
+// Loads the cached image of the URL. An image whose bytes no longer match
+// the hash it was stored under is removed and counts as a miss, so the
+// next download replaces it.
+func (c *diskCache) load(rawURL string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	p, ok := c.path(rawURL)
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(p)
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	// Caches written before images were shared hold the image itself
+	hash, _, isRef := parseCacheRef(data)
+	if !isRef {
+		return data, true
+	}
+	data, ok = c.loadObject(hash)
+	return data, ok
+}
+
+// Reads a stored image, checking it against its hash
+func (c *diskCache) loadObject(hash string) ([]byte, bool) {
+	obj := c.objectPath(hash)
+	data, err := os.ReadFile(obj)
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	if contentHash(data) != hash {
+		debugf("Corrupt cache entry %s, discarding\n", obj)
+		os.Remove(obj)
+		return nil, false
+	}
+	return data, true
+}
+
+// Splits a reference file into the hash of the image and the URL it was
+// downloaded from (empty for references written without one)
+func parseCacheRef(data []byte) (string, string, bool) {
+	if len(data) > 4096 {
+		return "", "", false
+	}
+	first, rest, _ := strings.Cut(string(data), "\n")
+	hash, ok := strings.CutPrefix(strings.TrimSpace(first), cacheRefPrefix)
+	if !ok || len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return "", "", false
+	}
+	return hash, strings.TrimSpace(rest), true
+}
+
+func (c *diskCache) store(rawURL string, data []byte) error {
+	if c == nil {
+		return nil
+	}
+	p, ok := c.path(rawURL)
+	if !ok {
+		return nil
+	}
+	hash := contentHash(data)
+	obj := c.objectPath(hash)
+	if _, err := os.Stat(obj); err != nil {
+		if err := os.MkdirAll(filepath.Dir(obj), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(obj, data, 0o644); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// The URL lets -verify-cache download the image again
+	return os.WriteFile(p, []byte(cacheRefPrefix+hash+"\n"+rawURL+"\n"), 0o644)
+}
+
+// Drops the URL's entry; the stored image stays for other URLs referring to it
+func (c *diskCache) remove(rawURL string) {
+	if c == nil {
+		return
+	}
+	if p, ok := c.path(rawURL); ok {
+		os.Remove(p)
+	}
+}
+
+// Outcome of -verify-cache
+type verifyStats struct {
+	Checked  int
+	Repaired int
+	Dropped  int
+}
+
+// Checks every cached image against its hash. Corrupt images are
+// downloaded again from the URL they came from; entries that cannot be
+// restored, and older entries holding an undecodable image, are removed.
+func verifyCache(f *fetcher) (verifyStats, error) {
+	var stats verifyStats
+	c := f.cache
+	good := make(map[string]bool)
+	err := filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".objects" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		stats.Checked++
+
+		hash, rawURL, isRef := parseCacheRef(data)
+		if !isRef {
+			if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+				logf("Removing undecodable entry %s\n", p)
+				stats.Dropped++
+				return os.Remove(p)
+			}
+			return nil
+		}
+		if good[hash] {
+			return nil
+		}
+		if _, ok := c.loadObject(hash); ok {
+			good[hash] = true
+			return nil
+		}
+
+		if rawURL != "" {
+			f.limits.wait(rawURL)
+			if fresh, err := download(f.client, f.header, rawURL, f.maxBytes); err == nil {
+				if err := c.store(rawURL, fresh); err != nil {
+					return err
+				}
+				logf("Repaired %s\n", rawURL)
+				stats.Repaired++
+				good[contentHash(fresh)] = true
+				return nil
+			}
+		}
+		logf("Removing unrecoverable entry %s\n", p)
+		stats.Dropped++
+		return os.Remove(p)
+	})
+	return stats, err
+}
+
+// Outcome of filling the cache ahead of a render
+type warmStats struct {
+	Cached     int
+	Downloaded int
+	Missing    int
+	Bytes      int64
+}
+
+// Downloads every code's image into the disk cache without rendering,
+// counting the codes that were already cached separately
+func warmCache(f *fetcher, ids []string) warmStats {
+	var stats warmStats
+	for i, id := range ids {
+		if id == "" {
+			continue
+		}
+		state := "downloaded"
+		if f.cached(id) {
+			stats.Cached++
+			state = "cached"
+		} else if data, _, _, err := f.fetchImage(id); err == nil {
+			stats.Downloaded++
+			stats.Bytes += int64(len(data))
+		} else {
+			stats.Missing++
+			state = statusLabel(statusNotFound)
+		}
+		logf("[%02d/%02d] Warming ID: %s %s\n", i+1, len(ids), id, state)
+	}
+	return stats
+}
+
+// Reports whether any of the code's image URLs already has a cached copy
+func (f *fetcher) cached(id string) bool {
+	for _, rawURL := range f.source.imageURLs(id) {
+		if _, ok := f.cache.load(rawURL); ok {
+			return true
+		}
+	}
+	return false
+}
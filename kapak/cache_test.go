@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheStoresSharedImageOnce(t *testing.T) {
+	c, err := newDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := testJPEG(t, 40, 60)
+	urls := []string{"https://example.com/a/1.jpg", "https://example.com/a/2.jpg", "https://cdn.example.com/3.jpg"}
+	for _, rawURL := range urls {
+		if err := c.store(rawURL, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := filepath.Glob(filepath.Join(c.dir, ".objects", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || filepath.Base(objects[0]) != contentHash(img) {
+		t.Fatalf("stored objects %v, want one named %s", objects, contentHash(img))
+	}
+	for _, rawURL := range urls {
+		data, ok := c.load(rawURL)
+		if !ok || !bytes.Equal(data, img) {
+			t.Errorf("%s: cached copy lost", rawURL)
+		}
+		// Each URL keeps only a reference, not another copy of the image
+		p, _ := c.path(rawURL)
+		if info, err := os.Stat(p); err != nil || info.Size() >= int64(len(img)) {
+			t.Errorf("%s: reference file missing or holding the image", rawURL)
+		}
+	}
+}
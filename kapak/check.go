@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Reports whether a URL serves content without downloading the body.
+// Servers that reject HEAD are retried with a single-byte ranged GET.
+func probe(client *http.Client, header http.Header, rawURL string) error {
+	status, err := probeWith(client, header, "HEAD", rawURL)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented {
+		status, err = probeWith(client, header, "GET", rawURL)
+		if err != nil {
+			return err
+		}
+	}
+	if status != http.StatusOK && status != http.StatusPartialContent {
+		return fmt.Errorf("status: %d", status)
+	}
+	return nil
+}
+
+func probeWith(client *http.Client, header http.Header, method, rawURL string) (int, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", httpUserAgent)
+	addHeaders(req, header)
+	if method == "GET" {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Returns the first candidate URL that is available for the code, bypassing the cache
+func (f *fetcher) checkImage(id string) (string, error) {
+	for _, rawURL := range f.source.imageURLs(id) {
+		f.limits.wait(rawURL)
+		if err := probe(f.client, f.header, rawURL); err == nil {
+			return rawURL, nil
+		}
+	}
+	return "", fmt.Errorf("image not found")
+}
+
+// Checks all codes with a few concurrent probes and splits them into
+// available and missing codes, both in input order
+func prefetch(f *fetcher, ids []string) ([]string, []string) {
+	available := make([]bool, len(ids))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(prefetchWorkers, len(ids)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// Blank cells from -keep-blanks stay in place
+				if ids[i] == "" {
+					available[i] = true
+					continue
+				}
+				_, err := f.checkImage(ids[i])
+				available[i] = err == nil
+			}
+		}()
+	}
+	for i := range ids {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var hits, misses []string
+	for i, id := range ids {
+		if available[i] {
+			hits = append(hits, id)
+		} else {
+			misses = append(misses, id)
+		}
+	}
+	return hits, misses
+}
+
+// Prints a per-code availability table and returns the number of available codes
+func runCheck(f *fetcher, ids []string, w io.Writer) int {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tSTATUS\tURL")
+
+	found, checked := 0, 0
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		checked++
+		rawURL, err := f.checkImage(id)
+		if err != nil {
+			fmt.Fprintf(tw, "%s\tNOT FOUND\t-\n", id)
+			continue
+		}
+		found++
+		fmt.Fprintf(tw, "%s\tOK\t%s\n", id, rawURL)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "%d/%d codes available.\n", found, checked)
+	return found
+}
+
+// Downloads the source's sample codes, bypassing the cache, and reports
+// whether they were served as images and how long each took
+func runSelftest(f *fetcher, w io.Writer) bool {
+	if len(f.source.SampleCodes) == 0 {
+		fmt.Fprintf(w, "Source %s has no sample codes to test.\n", f.source.Name)
+		return false
+	}
+
+	served := 0
+	var total time.Duration
+	for _, id := range f.source.SampleCodes {
+		if elapsed, err := selftestCode(f, id, w); err != nil {
+			fmt.Fprintf(w, "%s: FAIL (%v)\n", id, err)
+		} else {
+			served++
+			total += elapsed
+		}
+	}
+
+	if served == 0 {
+		fmt.Fprintf(w, "Source %s is not serving images.\n", f.source.Name)
+		return false
+	}
+	avg := total / time.Duration(served)
+	fmt.Fprintf(w, "Source %s is reachable: %d/%d samples served, average latency %s.\n", f.source.Name, served, len(f.source.SampleCodes), avg.Round(time.Millisecond))
+	return true
+}
+
+// Tries the code's URLs in order and reports the first one that decodes as an image
+func selftestCode(f *fetcher, id string, w io.Writer) (time.Duration, error) {
+	var lastErr error
+	for _, rawURL := range f.source.imageURLs(id) {
+		f.limits.wait(rawURL)
+		start := time.Now()
+		data, err := download(f.client, f.header, rawURL, f.maxBytes)
+		elapsed := time.Since(start)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			lastErr = fmt.Errorf("%s: not an image: %w", rawURL, err)
+			continue
+		}
+		fmt.Fprintf(w, "%s: OK %dx%d, %d bytes in %s (%s)\n", id, config.Width, config.Height, len(data), elapsed.Round(time.Millisecond), rawURL)
+		return elapsed, nil
+	}
+	return 0, lastErr
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/atotto/clipboard"
+)
+
+// Returns the clipboard text; headless sessions without a clipboard
+// utility fail here with a readable error
+func readClipboard() (string, error) {
+	if clipboard.Unsupported {
+		return "", fmt.Errorf("no clipboard access (on Linux install xclip, xsel or wl-clipboard)")
+	}
+	text, err := clipboard.ReadAll()
+	if err != nil {
+		return "", fmt.Errorf("no clipboard access: %w", err)
+	}
+	return text, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	ansiReset = "\033[0m"
+	ansiRed   = "\033[31m"
+	ansiGreen = "\033[32m"
+)
+
+var useColor bool
+
+// Resolves -color: auto colors only a terminal stdout and honors NO_COLOR
+func resolveColorMode(mode string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if _, set := os.LookupEnv("NO_COLOR"); set {
+			return false, nil
+		}
+		stat, err := os.Stdout.Stat()
+		return err == nil && (stat.Mode()&os.ModeCharDevice) != 0, nil
+	}
+	return false, fmt.Errorf("color must be always, auto or never")
+}
+
+func colorize(text, color string) string {
+	if !useColor {
+		return text
+	}
+	return color + text + ansiReset
+}
+
+// Short progress label for an item status
+func statusLabel(status string) string {
+	switch status {
+	case statusOK:
+		return colorize("OK", ansiGreen)
+	case statusInvalidFormat:
+		return colorize("FAIL (invalid format)", ansiRed)
+	}
+	return colorize("FAIL (not found)", ansiRed)
+}
+
+var ansiSequence = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// Removes color codes from messages written to non-terminal destinations
+type ansiStripper struct {
+	w io.Writer
+}
+
+func (a ansiStripper) Write(p []byte) (int, error) {
+	if _, err := a.w.Write(ansiSequence.ReplaceAll(p, nil)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
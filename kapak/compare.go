@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Parses -compare as two source names or URL templates with %s for the
+// code; a template is named after its host
+func parseCompare(value string) ([2]imageSource, error) {
+	var sources [2]imageSource
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return sources, fmt.Errorf("need exactly two sources, e.g. dr,https://example.com/%%s.jpg")
+	}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if !strings.Contains(part, "%s") {
+			src, err := lookupSource(part)
+			if err != nil {
+				return sources, err
+			}
+			sources[i] = src
+			continue
+		}
+		if err := validateURLTemplate(part); err != nil {
+			return sources, err
+		}
+		u, err := url.Parse(strings.ReplaceAll(part, "%s", "x"))
+		if err != nil || u.Host == "" {
+			return sources, fmt.Errorf("url template %q has no host", part)
+		}
+		sources[i] = imageSource{Name: u.Hostname(), URLFmts: []string{part}}
+	}
+	if sources[0].Name == sources[1].Name {
+		sources[0].Name += "#1"
+		sources[1].Name += "#2"
+	}
+	return sources, nil
+}
+
+// One fetcher per compared source, sharing the client, cache and pacing of base
+func compareFetchers(base *fetcher, sources [2]imageSource) [2]*fetcher {
+	var fetchers [2]*fetcher
+	for i, src := range sources {
+		f := *base
+		f.source = src
+		fetchers[i] = &f
+	}
+	return fetchers
+}
+
+// Repeats every code so that the two sources' cells of a code sit side by side
+func pairIDs(ids []string) []string {
+	paired := make([]string, 0, 2*len(ids))
+	for _, id := range ids {
+		paired = append(paired, id, id)
+	}
+	return paired
+}
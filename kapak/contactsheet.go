@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	sheetDPI = 100
+	// Width of -page-previews images in pixels
+	previewWidthPx = 400
+)
+
+// Renders the items as PNG contact sheets, one image per page, using the
+// same placement as the PDF. Covers are drawn from the already fetched
+// data; PDF-only decorations such as shadows and crop marks are left out.
+// A non-zero maxWidth scales the pages down to at most that many pixels wide.
+func renderPNG(items []coverItem, opts renderOptions, maxWidth int) ([][]byte, error) {
+	page := opts.Page
+	cells := planCells(items, opts)
+
+	pages := 0
+	for _, cell := range cells {
+		pages = max(pages, cell.Page+1)
+	}
+
+	bg := color.RGBA{255, 255, 255, 255}
+	if c := opts.BackgroundColor; c != nil {
+		bg = color.RGBA{uint8(c[0]), uint8(c[1]), uint8(c[2]), 255}
+	}
+	sheets := make([]*image.RGBA, pages)
+	for p := range sheets {
+		sheets[p] = image.NewRGBA(image.Rect(0, 0, mmToPx(page.Width), mmToPx(page.Height)))
+		draw.Draw(sheets[p], sheets[p].Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	}
+
+	border := color.RGBA{cellBorderGray, cellBorderGray, cellBorderGray, 255}
+	for i, item := range items {
+		if item.Status == statusBlank && !opts.BlankBorders {
+			continue
+		}
+		dst, cell := sheets[cells[i].Page], cells[i]
+		if opts.Zebra.tinted(cell) {
+			tint := image.Rect(mmToPx(cell.X), mmToPx(cell.Y), mmToPx(cell.X+cell.Width), mmToPx(cell.Y+cell.Height))
+			draw.Draw(dst, tint, image.NewUniform(color.RGBA{zebraGray, zebraGray, zebraGray, 255}), image.Point{}, draw.Src)
+		}
+
+		boxW, boxH := cell.Width-contentPaddingMM, cell.Height-contentPaddingMM
+		if opts.Aspect > 0 && !opts.Masonry {
+			boxW, boxH = fitBox(boxW, boxH, opts.Aspect)
+		}
+		borderW := boxW + contentPaddingMM - (2 * cellBorderInsetMM)
+		borderH := boxH + contentPaddingMM - (2 * cellBorderInsetMM)
+		strokeRect(dst, cell.X+(cell.Width-borderW)/2, cell.Y+(cell.Height-borderH)/2, borderW, borderH, border)
+		if item.Status == statusBlank {
+			continue
+		}
+
+		boxX := cell.X + (cell.Width-boxW)/2
+		boxTop := cell.Y + (cell.Height-boxH)/2
+
+		// A cover that passed the header check may still be truncated;
+		// it gets the invalid placeholder, as in the PDF
+		var src image.Image
+		if item.Status == statusOK {
+			var err error
+			if src, _, err = image.Decode(bytes.NewReader(item.Data)); err != nil {
+				logf("Warning: unable to draw the cover of %s: %v; marking it invalid.\n", item.Code, err)
+				item.Status = statusInvalidFormat
+			}
+		}
+		if item.Status != statusOK {
+			label := "NOT FOUND"
+			if item.Status == statusInvalidFormat {
+				label = "INVALID FORMAT"
+			}
+			drawSheetText(dst, boxX, cell.Y+cell.Height/2, boxW, label)
+			drawSheetText(dst, boxX, boxTop+boxH-1, boxW, item.codeLine())
+			continue
+		}
+
+		imageBoxH, imageTop := boxH, boxTop
+		captionY := boxTop + boxH - 1
+		if item.Caption != "" && opts.CaptionPosition != captionOverlay {
+			imageBoxH -= captionHeightMM
+			if opts.CaptionPosition == captionAbove {
+				imageTop += captionHeightMM
+				captionY = boxTop + captionHeightMM - 1
+			}
+		}
+		mat := max(0, min(opts.MatWidth, boxW/4, imageBoxH/4))
+		w, h := fitImage(item.Config, boxW-2*mat, imageBoxH-2*mat)
+		x := cell.X + (cell.Width-w)/2
+		y := imageTop + (imageBoxH-h)/2
+		if mat > 0 {
+			c := opts.MatColor
+			frame := image.Rect(mmToPx(x-mat), mmToPx(y-mat), mmToPx(x+w+mat), mmToPx(y+h+mat))
+			draw.Draw(dst, frame, image.NewUniform(color.RGBA{uint8(c[0]), uint8(c[1]), uint8(c[2]), 255}), image.Point{}, draw.Src)
+		}
+		rect := image.Rect(mmToPx(x), mmToPx(y), mmToPx(x+w), mmToPx(y+h))
+		draw.CatmullRom.Scale(dst, rect, src, src.Bounds(), draw.Over, nil)
+
+		if item.Caption != "" {
+			if opts.CaptionPosition == captionOverlay {
+				band := image.Rect(mmToPx(x), mmToPx(y+h-captionHeightMM), mmToPx(x+w), mmToPx(y+h))
+				draw.Draw(dst, band, image.NewUniform(color.NRGBA{255, 255, 255, 191}), image.Point{}, draw.Over)
+				captionY = y + h - 1
+			}
+			drawSheetText(dst, boxX, captionY, boxW, item.Caption)
+		}
+	}
+
+	outputs := make([][]byte, 0, pages)
+	for _, sheet := range sheets {
+		if w := sheet.Bounds().Dx(); maxWidth > 0 && w > maxWidth {
+			small := image.NewRGBA(image.Rect(0, 0, maxWidth, sheet.Bounds().Dy()*maxWidth/w))
+			draw.ApproxBiLinear.Scale(small, small.Bounds(), sheet, sheet.Bounds(), draw.Src, nil)
+			sheet = small
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, sheet); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, buf.Bytes())
+	}
+	return outputs, nil
+}
+
+func mmToPx(mm float64) int {
+	return int(mm/25.4*sheetDPI + 0.5)
+}
+
+func strokeRect(dst *image.RGBA, x, y, w, h float64, c color.Color) {
+	x0, y0, x1, y1 := mmToPx(x), mmToPx(y), mmToPx(x+w), mmToPx(y+h)
+	for px := x0; px <= x1; px++ {
+		dst.Set(px, y0, c)
+		dst.Set(px, y1, c)
+	}
+	for py := y0; py <= y1; py++ {
+		dst.Set(x0, py, c)
+		dst.Set(x1, py, c)
+	}
+}
+
+// Writes one line of ASCII text centered within w, with its baseline at y
+func drawSheetText(dst *image.RGBA, x, y, w float64, text string) {
+	d := font.Drawer{Dst: dst, Src: image.Black, Face: basicfont.Face7x13}
+	text = toASCII(text)
+	avail := fixed.I(mmToPx(w))
+	for len(text) > 0 && d.MeasureString(text) > avail {
+		text = text[:len(text)-1]
+	}
+	left := fixed.I(mmToPx(x)) + (avail-d.MeasureString(text))/2
+	d.Dot = fixed.Point26_6{X: left, Y: fixed.I(mmToPx(y))}
+	d.DrawString(text)
+}
+
+// Writes a low resolution PNG of every grid page as DIR/NAME-N.png
+func writePagePreviews(dir, name string, items []coverItem, opts renderOptions) error {
+	previews, err := renderPNG(items, opts, previewWidthPx)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for i, preview := range previews {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%s-%d.png", name, i+1)), preview, 0o644); err != nil {
+			return err
+		}
+	}
+	logf("Saved %d page previews to %s\n", len(previews), dir)
+	return nil
+}
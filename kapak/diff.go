@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Returns the codes only in next and only in prev, each once and in the
+// order of its own list
+func diffCodes(prev, next []string) ([]string, []string) {
+	inPrev := make(map[string]bool, len(prev))
+	for _, id := range prev {
+		inPrev[id] = true
+	}
+	inNext := make(map[string]bool, len(next))
+	for _, id := range next {
+		inNext[id] = true
+	}
+	return onlyIn(next, inPrev), onlyIn(prev, inNext)
+}
+
+func onlyIn(ids []string, other map[string]bool) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if id == "" || other[id] || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
+
+func reportDiff(w io.Writer, added, removed []string) {
+	for _, id := range added {
+		fmt.Fprintf(w, "+ %s\n", id)
+	}
+	for _, id := range removed {
+		fmt.Fprintf(w, "- %s\n", id)
+	}
+	fmt.Fprintf(w, "%d added, %d removed.\n", len(added), len(removed))
+}
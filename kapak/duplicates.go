@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Reads a -placeholder-hashes file of SHA-256 hex digests, one per line.
+// Only the first field counts, so sha256sum output can be used as is;
+// empty lines and '#' comments are skipped.
+func loadHashSet(filename string) (map[string]bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		hash := strings.ToLower(fields[0])
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha256.Size {
+			return nil, fmt.Errorf("line %d: %q is not a SHA-256 hex digest", lineNo, fields[0])
+		}
+		hashes[hash] = true
+	}
+	return hashes, scanner.Err()
+}
+
+// Groups codes by the hash of their cover image, in first-seen order
+type duplicateIndex struct {
+	order  []string
+	groups map[string][]string
+}
+
+func newDuplicateIndex() *duplicateIndex {
+	return &duplicateIndex{groups: make(map[string][]string)}
+}
+
+// Records the code and returns the first code seen with the same hash, if any
+func (d *duplicateIndex) add(hash, code string) string {
+	group := d.groups[hash]
+	if len(group) == 0 {
+		d.order = append(d.order, hash)
+	}
+	d.groups[hash] = append(group, code)
+	if len(group) > 0 {
+		return group[0]
+	}
+	return ""
+}
+
+// Indexes the hashed items, marking each repeat with the first code sharing its cover
+func findDuplicates(items []coverItem) *duplicateIndex {
+	dupes := newDuplicateIndex()
+	for i := range items {
+		if items[i].Hash != "" {
+			items[i].DuplicateOf = dupes.add(items[i].Hash, items[i].Code)
+		}
+	}
+	return dupes
+}
+
+func (d *duplicateIndex) report(w io.Writer) {
+	found := 0
+	for _, hash := range d.order {
+		group := d.groups[hash]
+		if len(group) < 2 {
+			continue
+		}
+		if found == 0 {
+			fmt.Fprintln(w, "Codes sharing an identical cover:")
+		}
+		found++
+		fmt.Fprintf(w, "  %s\n", strings.Join(group, ", "))
+	}
+	if found == 0 {
+		fmt.Fprintln(w, "No duplicate covers found.")
+	}
+}
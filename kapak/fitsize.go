@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"os"
+)
+
+const (
+	minJPEGQuality = 10
+	maxJPEGQuality = 95
+)
+
+// Renders the PDF in memory. When it exceeds maxBytes, JPEG covers are
+// recompressed at the highest quality (found by binary search) that fits.
+// The returned quality is 0 when the original images already fit; if even
+// the lowest quality is too large, that smallest result is returned.
+func renderWithinSize(items []coverItem, opts renderOptions, maxBytes int) ([]byte, *manifest, int, error) {
+	output, layout, err := renderBytes(items, opts)
+	if err != nil || len(output) <= maxBytes {
+		return output, layout, 0, err
+	}
+
+	decoded := make([]image.Image, len(items))
+	for i, item := range items {
+		if item.Status != statusOK || item.Format != "JPG" {
+			continue
+		}
+		if img, _, err := image.Decode(bytes.NewReader(item.Data)); err == nil {
+			decoded[i] = img
+		}
+	}
+
+	recompress := func(quality int) ([]byte, *manifest, error) {
+		variant := append([]coverItem(nil), items...)
+		for i, img := range decoded {
+			if img == nil {
+				continue
+			}
+			data, err := encodeJPEG(img, quality)
+			if err != nil {
+				return nil, nil, err
+			}
+			variant[i].Data = data
+		}
+		return renderBytes(variant, opts)
+	}
+
+	var best []byte
+	var bestLayout *manifest
+	bestQuality := 0
+	lo, hi := minJPEGQuality, maxJPEGQuality
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		debugf("Trying JPEG quality %d\n", mid)
+		out, l, err := recompress(mid)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if len(out) <= maxBytes {
+			best, bestLayout, bestQuality = out, l, mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	if best == nil {
+		out, l, err := recompress(minJPEGQuality)
+		return out, l, minJPEGQuality, err
+	}
+	return best, bestLayout, bestQuality, nil
+}
+
+func renderBytes(items []coverItem, opts renderOptions) ([]byte, *manifest, error) {
+	pdf, layout := renderPDF(items, opts)
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), layout, nil
+}
+
+// Renders straight into the file, so no second copy of the document is
+// held in memory; the file is closed either way
+func renderToFile(items []coverItem, opts renderOptions, f *os.File) (*manifest, error) {
+	pdf, layout := renderPDF(items, opts)
+	err := pdf.Output(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return layout, err
+}
@@ -2,4 +2,9 @@ module kapak
 
 go 1.21.3
 
-require github.com/go-pdf/fpdf v0.9.0
+require (
+	github.com/atotto/clipboard v0.1.4
+	github.com/boombuler/barcode v1.1.0
+	github.com/go-pdf/fpdf v0.9.0
+	golang.org/x/image v0.18.0
+)
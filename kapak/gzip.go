@@ -0,0 +1,18 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+)
+
+// Transparently decompresses gzip input, recognized by its magic number;
+// anything else is passed through unchanged
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const gzipTestList = "# Raf 3\n0001960520002\n\nISBN 0-306-40615-2\n0000000259833\n"
+
+func TestMaybeGunzip(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(gzipTestList))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"0001960520002", "9780306406157", "0000000259833"}
+	for name, input := range map[string][]byte{
+		"gzipped": compressed.Bytes(),
+		"plain":   []byte(gzipTestList),
+	} {
+		r, err := maybeGunzip(bytes.NewReader(input))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		ids, err := scanIDs(r, scanOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !slices.Equal(ids, want) {
+			t.Errorf("%s: got codes %v, want %v", name, ids, want)
+		}
+	}
+}
+
+func TestMaybeGunzipCorrupt(t *testing.T) {
+	// The magic number alone promises gzip, so a broken header is an error
+	if _, err := maybeGunzip(strings.NewReader("\x1f\x8bnot really gzip")); err == nil {
+		t.Error("corrupt gzip header accepted")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Repeatable -header flag collecting "Key: Value" request headers
+type headerList struct {
+	header http.Header
+}
+
+func (h *headerList) String() string {
+	if h == nil || h.header == nil {
+		return ""
+	}
+	var parts []string
+	for key, values := range h.header {
+		for _, value := range values {
+			parts = append(parts, key+": "+value)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (h *headerList) Set(value string) error {
+	key, val, err := parseHeader(value)
+	if err != nil {
+		return err
+	}
+	if h.header == nil {
+		h.header = make(http.Header)
+	}
+	h.header.Add(key, val)
+	return nil
+}
+
+func parseHeader(value string) (string, string, error) {
+	key, val, ok := strings.Cut(value, ":")
+	key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("header must be in \"Key: Value\" form")
+	}
+	for _, r := range key {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r) {
+			return "", "", fmt.Errorf("invalid character %q in header name %q", r, key)
+		}
+	}
+	if strings.ContainsAny(val, "\r\n") {
+		return "", "", fmt.Errorf("header value for %q must be a single line", key)
+	}
+	return key, val, nil
+}
+
+// Adds the configured headers to an image request. They go on the request
+// rather than the transport, so the client's redirect policy still drops
+// credentials when a redirect leads to another host.
+func addHeaders(req *http.Request, header http.Header) {
+	for key, values := range header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+}
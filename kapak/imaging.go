@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	defaultResample = "bilinear"
+	jpegQuality     = 90
+)
+
+var resamplers = map[string]draw.Interpolator{
+	"nearest":    draw.NearestNeighbor,
+	"bilinear":   draw.BiLinear,
+	"catmullrom": draw.CatmullRom,
+}
+
+func lookupResampler(name string) (draw.Interpolator, error) {
+	interp, ok := resamplers[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("resample must be nearest, bilinear or catmullrom")
+	}
+	return interp, nil
+}
+
+// Pixel transforms applied between download and embedding
+type imageOptions struct {
+	MaxWidth int
+	Resample draw.Interpolator
+	// Unsharp mask strength applied after a downscale; 0 disables it
+	Sharpen float64
+	Flip    flipMode
+	// Re-encode progressive JPEGs, which fpdf embeds as-is, to baseline
+	Baseline bool
+	// Width/height ratio every cover is center-cropped to; 0 keeps the shape
+	ForceAspect float64
+}
+
+func (o imageOptions) active() bool {
+	return o.MaxWidth > 0 || o.Flip != flipNone || o.Baseline || o.ForceAspect > 0
+}
+
+// Mirroring applied to every cover, for scans that come in flipped
+type flipMode int
+
+const (
+	flipNone flipMode = iota
+	flipHorizontal
+	flipVertical
+	flipBoth
+)
+
+func parseFlip(value string) (flipMode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "none":
+		return flipNone, nil
+	case "h":
+		return flipHorizontal, nil
+	case "v":
+		return flipVertical, nil
+	case "both":
+		return flipBoth, nil
+	}
+	return flipNone, fmt.Errorf("flip must be h, v or both")
+}
+
+// Applies the configured transforms to an encoded image. The original bytes
+// are returned untouched when no transform changes the image.
+func processImage(data []byte, format string, opts imageOptions) ([]byte, string, error) {
+	if !opts.active() {
+		return data, format, nil
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	bounds := image.Rect(0, 0, config.Width, config.Height)
+	crop := opts.ForceAspect > 0 && aspectCrop(bounds, opts.ForceAspect) != bounds
+	if crop {
+		bounds = aspectCrop(bounds, opts.ForceAspect)
+	}
+	downscale := opts.MaxWidth > 0 && bounds.Dx() > opts.MaxWidth
+	rebase := opts.Baseline && format == "JPG" && isProgressiveJPEG(data)
+	if !downscale && opts.Flip == flipNone && !rebase && !crop {
+		return data, format, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if crop {
+		src = toRGBA(src).SubImage(aspectCrop(src.Bounds(), opts.ForceAspect))
+	}
+	var img *image.RGBA
+	if downscale {
+		img = resize(src, opts.MaxWidth, opts.Resample)
+		if opts.Sharpen > 0 {
+			img = unsharpMask(img, opts.Sharpen)
+		}
+	} else {
+		img = toRGBA(src)
+	}
+	if opts.Flip != flipNone {
+		img = flip(img, opts.Flip)
+	}
+	return encodeImage(img, format)
+}
+
+// Walks the JPEG marker segments up to the first scan and reports whether
+// the frame is progressive (SOF2) rather than baseline
+func isProgressiveJPEG(data []byte) bool {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return false
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return false
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			i++
+			continue
+		case marker == 0xC2 || marker == 0xC6 || marker == 0xCA || marker == 0xCE:
+			return true
+		case marker == 0xDA:
+			return false
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			i += 2
+			continue
+		}
+		i += 2 + (int(data[i+2])<<8 | int(data[i+3]))
+	}
+	return false
+}
+
+// Largest centered rectangle of the given width/height ratio inside b
+func aspectCrop(b image.Rectangle, ratio float64) image.Rectangle {
+	w, h := b.Dx(), b.Dy()
+	if float64(w)/float64(h) > ratio {
+		w = max(1, int(float64(h)*ratio+0.5))
+	} else {
+		h = max(1, int(float64(w)/ratio+0.5))
+	}
+	origin := b.Min.Add(image.Pt((b.Dx()-w)/2, (b.Dy()-h)/2))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(w, h))}
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// Mirrors the image horizontally, vertically or both
+func flip(src *image.RGBA, mode flipMode) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		sy := y
+		if mode == flipVertical || mode == flipBoth {
+			sy = b.Max.Y - 1 - (y - b.Min.Y)
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			sx := x
+			if mode == flipHorizontal || mode == flipBoth {
+				sx = b.Max.X - 1 - (x - b.Min.X)
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// Scales the image down to the given width, keeping its aspect ratio
+func resize(src image.Image, width int, interp draw.Interpolator) *image.RGBA {
+	b := src.Bounds()
+	height := max(1, b.Dy()*width/b.Dx())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	interp.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
+
+// Sharpens by adding back the difference between the image and a 3x3
+// Gaussian blur of it, scaled by amount
+func unsharpMask(src *image.RGBA, amount float64) *image.RGBA {
+	kernel := [3][3]float64{{1, 2, 1}, {2, 4, 2}, {1, 2, 1}}
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var blur [3]float64
+			for ky := -1; ky <= 1; ky++ {
+				for kx := -1; kx <= 1; kx++ {
+					px := min(max(x+kx, b.Min.X), b.Max.X-1)
+					py := min(max(y+ky, b.Min.Y), b.Max.Y-1)
+					off := src.PixOffset(px, py)
+					weight := kernel[ky+1][kx+1] / 16
+					for c := 0; c < 3; c++ {
+						blur[c] += weight * float64(src.Pix[off+c])
+					}
+				}
+			}
+
+			off := src.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				orig := float64(src.Pix[off+c])
+				v := orig + amount*(orig-blur[c])
+				dst.Pix[off+c] = uint8(min(max(v, 0), 255))
+			}
+			dst.Pix[off+3] = src.Pix[off+3]
+		}
+	}
+	return dst
+}
+
+// Encodes the image back to its original format; anything but PNG becomes JPEG
+func encodeImage(img image.Image, format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if format == "PNG" {
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "PNG", nil
+	}
+	data, err := encodeJPEG(img, jpegQuality)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, "JPG", nil
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
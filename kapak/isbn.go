@@ -0,0 +1,89 @@
+package main
+
+import "strings"
+
+// Removes the hyphens and spaces ISBNs are usually printed with
+func compactISBN(s string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(s)
+}
+
+func isValidISBN10(s string) bool {
+	if len(s) != 10 {
+		return false
+	}
+	sum := 0
+	for i, r := range s {
+		var digit int
+		switch {
+		case r >= '0' && r <= '9':
+			digit = int(r - '0')
+		case (r == 'X' || r == 'x') && i == 9:
+			digit = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * digit
+	}
+	return sum%11 == 0
+}
+
+func isValidISBN13(s string) bool {
+	if len(s) != 13 || !isAllDigits(s) || !(strings.HasPrefix(s, "978") || strings.HasPrefix(s, "979")) {
+		return false
+	}
+	sum := 0
+	for i, r := range s {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(r-'0')
+	}
+	return sum%10 == 0
+}
+
+// Recognizes a line written as an ISBN: 10 or 13 digits (the ISBN-10 check
+// digit may be X), optionally hyphenated and prefixed with "ISBN". A
+// candidate with a valid checksum is returned in ISBN-13 form; the returned
+// string is empty when the checksum fails. Plain digit lines are product
+// codes as often as ISBNs, so they only count as ISBNs when bare is set;
+// otherwise the line must carry the prefix, separators or an X check digit.
+func normalizeISBN(line string, bare bool) (string, bool) {
+	s := strings.ToUpper(strings.TrimSpace(line))
+	rest := strings.TrimPrefix(s, "ISBN")
+	marked := rest != s
+	rest = strings.TrimLeft(rest, ": ")
+	s = compactISBN(rest)
+	marked = marked || s != rest || strings.HasSuffix(s, "X")
+	if !marked && !bare {
+		return "", false
+	}
+
+	switch {
+	case len(s) == 10 && isAllDigits(s[:9]) && (isAllDigits(s[9:]) || s[9] == 'X'):
+		if !isValidISBN10(s) {
+			return "", true
+		}
+		return isbn10To13(s), true
+	case len(s) == 13 && isAllDigits(s) && (strings.HasPrefix(s, "978") || strings.HasPrefix(s, "979")):
+		if !isValidISBN13(s) {
+			return "", true
+		}
+		return s, true
+	}
+	return "", false
+}
+
+// Converts a valid ISBN-10 to its 978-prefixed ISBN-13 equivalent
+func isbn10To13(s string) string {
+	base := "978" + s[:9]
+	sum := 0
+	for i, r := range base {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(r-'0')
+	}
+	return base + string(rune('0'+(10-sum%10)%10))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		line   string
+		bare   bool
+		want   string
+		isISBN bool
+	}{
+		// ISBN-10 and its ISBN-13 form give the same code
+		{"0-306-40615-2", false, "9780306406157", true},
+		{"978-0-306-40615-7", false, "9780306406157", true},
+		{"ISBN 080442957X", false, "9780804429573", true},
+		{"isbn: 0-8044-2957-x", false, "9780804429573", true},
+		{"ISBN9780804429573", false, "9780804429573", true},
+		{"0 19 852663 6", false, "9780198526636", true},
+		{"979-10-90636-07-1", false, "9791090636071", true},
+		{"080442957X", false, "9780804429573", true},
+
+		// Bad checksums are recognized as ISBNs but rejected
+		{"0-306-40615-3", false, "", true},
+		{"978-0-306-40615-8", false, "", true},
+		{"ISBN 0804429570", false, "", true},
+
+		// Plain digit lines are left alone unless bare ISBNs are allowed
+		{"0306406152", false, "", false},
+		{"0306406153", false, "", false},
+		{"9780306406157", false, "", false},
+		{"0306406152", true, "9780306406157", true},
+		{"0306406153", true, "", true},
+		{"9780306406157", true, "9780306406157", true},
+
+		// Anything else is left to the other code formats
+		{"0001960520002", true, "", false},
+		{"12345", true, "", false},
+		{"X804429570", true, "", false},
+	}
+	for _, tt := range tests {
+		got, isISBN := normalizeISBN(tt.line, tt.bare)
+		if got != tt.want || isISBN != tt.isISBN {
+			t.Errorf("normalizeISBN(%q, %v) = %q, %v; want %q, %v", tt.line, tt.bare, got, isISBN, tt.want, tt.isISBN)
+		}
+	}
+}
+
+func TestISBNInListAndTable(t *testing.T) {
+	tests := []struct {
+		cell   string
+		strict bool
+		want   string
+	}{
+		{"0306406152", false, "0306406152"},
+		{"0306406152", true, "9780306406157"},
+		{"ISBN 0-306-40615-2", false, "9780306406157"},
+		{"0001960520002", false, "0001960520002"},
+	}
+	for _, tt := range tests {
+		ids, err := scanIDs(strings.NewReader(tt.cell+"\n"), scanOptions{Strict: tt.strict})
+		if err != nil || len(ids) != 1 || ids[0] != tt.want {
+			t.Errorf("list %q (strict %v): got %v, %v; want %s", tt.cell, tt.strict, ids, err, tt.want)
+		}
+		if got := tableCode(tt.cell, tt.strict); got != tt.want {
+			t.Errorf("table %q (strict %v): got %q, want %s", tt.cell, tt.strict, got, tt.want)
+		}
+	}
+}
+
+func TestISBN10To13(t *testing.T) {
+	for isbn10, isbn13 := range map[string]string{
+		"0306406152": "9780306406157",
+		"080442957X": "9780804429573",
+		"0198526636": "9780198526636",
+		"1861972717": "9781861972712",
+	} {
+		if !isValidISBN10(isbn10) {
+			t.Errorf("%s: valid ISBN-10 rejected", isbn10)
+		}
+		if got := isbn10To13(isbn10); got != isbn13 {
+			t.Errorf("isbn10To13(%s) = %s, want %s", isbn10, got, isbn13)
+		}
+		if !isValidISBN13(isbn13) {
+			t.Errorf("%s: valid ISBN-13 rejected", isbn13)
+		}
+	}
+}
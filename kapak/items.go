@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"slices"
+	"sort"
+	"time"
+)
+
+// One input code and the outcome of fetching its cover
+type coverItem struct {
+	Code        string
+	Caption     string
+	URL         string
+	Status      string
+	Data        []byte
+	Format      string
+	Config      image.Config
+	Hash        string
+	DuplicateOf string
+	// Input line the code came from, with the title comment above it if any
+	Source string
+	// Time spent fetching the image, including failed attempts
+	Elapsed time.Duration
+	// Product page of the code, for the QR index
+	Link string
+	// Source the cover was fetched from when -compare renders several
+	Origin string
+}
+
+// Code as printed in a failed cell, with the source it was missing from
+func (c coverItem) codeLine() string {
+	if c.Origin == "" {
+		return c.Code
+	}
+	return c.Code + " (" + c.Origin + ")"
+}
+
+// Reports whether the item stands for a code that could not be shown;
+// blank cells are not failures
+func (c coverItem) failed() bool {
+	return c.Status != statusOK && c.Status != statusBlank
+}
+
+type fetchOptions struct {
+	MaxPixels  int
+	Image      imageOptions
+	Duplicates bool
+	// Fully decode every image instead of trusting its header, so that
+	// truncated or corrupt downloads count as invalid
+	StrictFormat bool
+	// Hashes of known junk images, such as generic category placeholders,
+	// that count as misses
+	Denylist map[string]bool
+}
+
+// Loads every cover in order, printing progress as it goes
+func collectItems(ids []string, verb string, load func(id string) coverItem) []coverItem {
+	items := make([]coverItem, 0, len(ids))
+	for i, id := range ids {
+		if id == "" {
+			items = append(items, coverItem{Status: statusBlank})
+			continue
+		}
+		item := load(id)
+		logf("[%02d/%02d] %s: %s %s\n", i+1, len(ids), verb, id, statusLabel(item.Status))
+		items = append(items, item)
+	}
+	return items
+}
+
+// Downloads and prepares the cover of a product code. An image that
+// arrives but cannot be decoded, or is a known placeholder, makes way for
+// the source's later URLs before the cell is given up. Undecodable images
+// are dropped from the cache so that the next run downloads them again.
+func fetchItem(f *fetcher, id string, opts fetchOptions) coverItem {
+	item := coverItem{Code: id, Status: statusNotFound}
+
+	start := time.Now()
+	urls := f.source.imageURLs(id)
+	for len(urls) > 0 {
+		data, format, rawURL, err := f.fetchFrom(urls)
+		if err != nil || data == nil {
+			break
+		}
+		item.URL, item.Status = rawURL, statusNotFound
+		item = prepareItem(item, data, format, opts)
+		if item.Status == statusInvalidFormat {
+			f.cache.remove(rawURL)
+		} else if item.Status != statusNotFound {
+			break
+		}
+		urls = urls[slices.Index(urls, rawURL)+1:]
+		if len(urls) > 0 {
+			debugf("Unusable image at %s, trying the next URL\n", rawURL)
+		}
+	}
+	item.Elapsed = time.Since(start)
+	return item
+}
+
+// Validates image data and applies the configured transforms for embedding
+func prepareItem(item coverItem, data []byte, format string, opts fetchOptions) coverItem {
+	if opts.Duplicates || len(opts.Denylist) > 0 {
+		item.Hash = contentHash(data)
+	}
+	if opts.Denylist[item.Hash] {
+		debugf("Rejecting %s: image %s is a known placeholder\n", item.Code, item.Hash[:12])
+		item.Hash = ""
+		return item
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && exceedsPixelLimit(config, opts.MaxPixels) {
+		debugf("Rejecting %s: %dx%d exceeds -max-pixels\n", item.Code, config.Width, config.Height)
+		err = fmt.Errorf("image too large")
+	}
+	// The full decode comes only after the header has passed the pixel limit
+	if err == nil && opts.StrictFormat {
+		if _, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+			debugf("Rejecting %s: %v\n", item.Code, err)
+		}
+	}
+	if err == nil && opts.Image.active() {
+		data, format, err = processImage(data, format, opts.Image)
+		if err == nil {
+			config, _, err = image.DecodeConfig(bytes.NewReader(data))
+		}
+	}
+	if err != nil {
+		item.Status = statusInvalidFormat
+		return item
+	}
+
+	item.Status = statusOK
+	item.Data = data
+	item.Format = format
+	item.Config = config
+	return item
+}
+
+// Lists the n slowest fetches, slowest first
+func reportSlowest(items []coverItem, n int) {
+	slow := make([]coverItem, 0, len(items))
+	for _, item := range items {
+		if item.Elapsed > 0 {
+			slow = append(slow, item)
+		}
+	}
+	if len(slow) == 0 {
+		return
+	}
+	sort.SliceStable(slow, func(i, j int) bool { return slow[i].Elapsed > slow[j].Elapsed })
+
+	logln("Slowest downloads:")
+	for _, item := range slow[:min(n, len(slow))] {
+		logf("  %s: %s\n", item.Code, item.Elapsed.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+// PNG whose header declares w x h RGB pixels but whose image data holds a
+// single row; nothing beyond the header is decodable
+func pngHeader(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	chunk := func(kind string, data []byte) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+		buf.WriteString(kind)
+		buf.Write(data)
+		binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(kind), data...)))
+	}
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], w)
+	binary.BigEndian.PutUint32(ihdr[4:], h)
+	ihdr[8], ihdr[9] = 8, 2 // 8-bit RGB
+	chunk("IHDR", ihdr)
+
+	var idat bytes.Buffer
+	zw := zlib.NewWriter(&idat)
+	zw.Write(make([]byte, 64))
+	zw.Close()
+	chunk("IDAT", idat.Bytes())
+	chunk("IEND", nil)
+	return buf.Bytes()
+}
+
+func TestPrepareItemRejectsOversizedHeader(t *testing.T) {
+	// 50000x50000 would need about 10 GB once decoded
+	data := pngHeader(t, 50000, 50000)
+	for _, strict := range []bool{false, true} {
+		opts := fetchOptions{MaxPixels: 40_000_000, StrictFormat: strict}
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		item := prepareItem(coverItem{Code: "1"}, data, "PNG", opts)
+		runtime.ReadMemStats(&after)
+
+		if item.Status != statusInvalidFormat {
+			t.Errorf("strict=%v: status %q, want %q", strict, item.Status, statusInvalidFormat)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("strict=%v: allocated %d bytes; the image was decoded", strict, allocated)
+		}
+	}
+}
+
+func TestFetchItemFallsBackFromCorruptImage(t *testing.T) {
+	img := testJPEG(t, 40, 60)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		if r.URL.Path == "/backup/1.jpg" {
+			w.Write(img)
+			return
+		}
+		w.Write(bytes.Repeat([]byte("garbage "), 64))
+	}))
+	defer srv.Close()
+
+	item := fetchItem(testFetcher(srv, "/%s.jpg", "/backup/%s.jpg"), "1", fetchOptions{})
+	if item.Status != statusOK {
+		t.Fatalf("status %q, want %q from the backup", item.Status, statusOK)
+	}
+	if item.URL != srv.URL+"/backup/1.jpg" || !bytes.Equal(item.Data, img) {
+		t.Errorf("got %d bytes from %s, want the backup image", len(item.Data), item.URL)
+	}
+	if item.Config.Width != 40 || item.Config.Height != 60 {
+		t.Errorf("got %dx%d, want 40x60", item.Config.Width, item.Config.Height)
+	}
+
+	item = fetchItem(testFetcher(srv, "/%s.jpg"), "1", fetchOptions{})
+	if item.Status != statusInvalidFormat {
+		t.Errorf("corrupt image without a backup: status %q, want %q", item.Status, statusInvalidFormat)
+	}
+}
+
+func TestPrepareItemStrictFormat(t *testing.T) {
+	// Large enough that its first half still holds the whole header
+	img := testJPEG(t, 400, 600)
+	truncated := img[:len(img)/2]
+	junk := bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef}, 64)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		strict bool
+		want   string
+	}{
+		{"valid", img, false, statusOK},
+		{"valid strict", img, true, statusOK},
+		// The header alone is intact, so only the full decode notices
+		{"truncated", truncated, false, statusOK},
+		{"truncated strict", truncated, true, statusInvalidFormat},
+		{"garbage", junk, false, statusInvalidFormat},
+		{"garbage strict", junk, true, statusInvalidFormat},
+	}
+	for _, tt := range tests {
+		item := prepareItem(coverItem{Code: "1"}, tt.data, "JPG", fetchOptions{StrictFormat: tt.strict})
+		if item.Status != tt.want {
+			t.Errorf("%s: status %q, want %q", tt.name, item.Status, tt.want)
+		}
+	}
+}
+
+func TestFetchItemKeepsCorruptImageOutOfCache(t *testing.T) {
+	img := testJPEG(t, 40, 60)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		if r.URL.Path == "/backup/1.jpg" {
+			w.Write(img)
+			return
+		}
+		w.Write(bytes.Repeat([]byte("garbage "), 64))
+	}))
+	defer srv.Close()
+
+	f := testFetcher(srv, "/%s.jpg", "/backup/%s.jpg")
+	var err error
+	if f.cache, err = newDiskCache(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if item := fetchItem(f, "1", fetchOptions{}); item.Status != statusOK {
+		t.Fatalf("status %q, want %q from the backup", item.Status, statusOK)
+	}
+	if _, ok := f.cache.load(srv.URL + "/1.jpg"); ok {
+		t.Error("corrupt primary image left in the cache")
+	}
+	if _, ok := f.cache.load(srv.URL + "/backup/1.jpg"); !ok {
+		t.Error("valid backup image not cached")
+	}
+}
+
+func TestFetchItemFallsBackFromDenylistedImage(t *testing.T) {
+	placeholder, img := testJPEG(t, 50, 50), testJPEG(t, 40, 60)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		if r.URL.Path == "/backup/1.jpg" {
+			w.Write(img)
+			return
+		}
+		w.Write(placeholder)
+	}))
+	defer srv.Close()
+
+	opts := fetchOptions{Denylist: map[string]bool{contentHash(placeholder): true}}
+	item := fetchItem(testFetcher(srv, "/%s.jpg", "/backup/%s.jpg"), "1", opts)
+	if item.Status != statusOK || item.URL != srv.URL+"/backup/1.jpg" {
+		t.Errorf("got %q from %s, want the backup image", item.Status, item.URL)
+	}
+
+	item = fetchItem(testFetcher(srv, "/%s.jpg"), "1", opts)
+	if item.Status != statusNotFound {
+		t.Errorf("placeholder without a backup: status %q, want %q", item.Status, statusNotFound)
+	}
+}
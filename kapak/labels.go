@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Reads a -labels file. Each line maps a code to its caption as
+// "code = label", e.g. "0001960520002 = Favorite Book"; empty lines and
+// '#' comments are skipped. Codes may also be given as product links.
+func loadLabels(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	labels := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, label, ok := strings.Cut(line, "=")
+		key, label = strings.TrimSpace(key), strings.TrimSpace(label)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected code = label", lineNo)
+		}
+		code := extractProductCode(key)
+		if code == "" {
+			code = key
+		}
+		labels[code] = label
+	}
+	return labels, scanner.Err()
+}
@@ -0,0 +1,214 @@
+package main
+
+import "slices"
+
+// Uniform grid of cells on a page, centered between the margins
+type gridLayout struct {
+	Rows       int
+	Cols       int
+	CellWidth  float64
+	CellHeight float64
+	OriginX    float64
+	OriginY    float64
+	GutterX    float64
+	GutterY    float64
+	RTL        bool
+}
+
+// Position of a single item; page, row and col are 0-based. Width and
+// Height cover all grid cells the item spans.
+type cellPlacement struct {
+	Page    int
+	Row     int
+	Col     int
+	RowSpan int
+	ColSpan int
+	X       float64
+	Y       float64
+	Width   float64
+	Height  float64
+}
+
+// Builds the grid for the page. A zero cell size stretches the cells to
+// fill the area inside the margins; otherwise the fixed-size grid is centered.
+func newGridLayout(page pageSpec, rows, cols int, cellW, cellH float64) gridLayout {
+	if cellW <= 0 || cellH <= 0 {
+		cellW = (page.Width - (2 * pageMarginXMM)) / float64(cols)
+		cellH = (page.Height - (2 * pageMarginYMM)) / float64(rows)
+	}
+	return gridLayout{
+		Rows:       rows,
+		Cols:       cols,
+		CellWidth:  cellW,
+		CellHeight: cellH,
+		OriginX:    (page.Width - float64(cols)*cellW) / 2,
+		OriginY:    (page.Height - float64(rows)*cellH) / 2,
+	}
+}
+
+func (g gridLayout) cellsPerPage() int {
+	return g.Rows * g.Cols
+}
+
+func (g gridLayout) pageCount(items int) int {
+	return (items + g.cellsPerPage() - 1) / g.cellsPerPage()
+}
+
+// Maps the i-th item to its cell, filling rows left to right (or right to
+// left) and spilling onto new pages
+func (g gridLayout) place(i int) cellPlacement {
+	pageIndex := i % g.cellsPerPage()
+	return g.span(i/g.cellsPerPage(), pageIndex/g.Cols, pageIndex%g.Cols, cellSpan{Rows: 1, Cols: 1})
+}
+
+// Placement of a block of cells whose top-left cell is at row and col in
+// reading order; columns are mirrored for right-to-left grids
+func (g gridLayout) span(page, row, col int, s cellSpan) cellPlacement {
+	if g.RTL {
+		col = g.Cols - col - s.Cols
+	}
+	return cellPlacement{
+		Page:    page,
+		Row:     row,
+		Col:     col,
+		RowSpan: s.Rows,
+		ColSpan: s.Cols,
+		X:       g.OriginX + (float64(col) * (g.CellWidth + g.GutterX)),
+		Y:       g.OriginY + (float64(row) * (g.CellHeight + g.GutterY)),
+		Width:   float64(s.Cols)*g.CellWidth + float64(s.Cols-1)*g.GutterX,
+		Height:  float64(s.Rows)*g.CellHeight + float64(s.Rows-1)*g.GutterY,
+	}
+}
+
+// Assigns successful items consecutive slots and starts the failed ones on
+// the page after the last cover, keeping each group in input order
+func groupMissSlots(items []coverItem, perPage int) []int {
+	order, breakAt := placementOrder(items, true)
+	slots := make([]int, len(items))
+	slot := 0
+	for n, i := range order {
+		if n == breakAt {
+			slot = (slot + perPage - 1) / perPage * perPage
+		}
+		slots[i] = slot
+		slot++
+	}
+	return slots
+}
+
+// Order in which items are laid out and the position in that order where
+// a new page starts (-1 for none). With groupMisses the failed items
+// follow all successful ones, starting on a fresh page.
+func placementOrder(items []coverItem, groupMisses bool) ([]int, int) {
+	order := make([]int, 0, len(items))
+	if !groupMisses {
+		for i := range items {
+			order = append(order, i)
+		}
+		return order, -1
+	}
+
+	var misses []int
+	for i, item := range items {
+		if !item.failed() {
+			order = append(order, i)
+		} else {
+			misses = append(misses, i)
+		}
+	}
+	breakAt := -1
+	if len(order) > 0 && len(misses) > 0 {
+		breakAt = len(order)
+	}
+	return append(order, misses...), breakAt
+}
+
+// Regrids a partial last page so its few cells grow to fill the area the
+// full grid covers, keeping the cell shape; a short final row is centered
+func (g gridLayout) compactLastPage(cells []cellPlacement) []cellPlacement {
+	if len(cells) == 0 {
+		return cells
+	}
+	last := 0
+	for _, cell := range cells {
+		last = max(last, cell.Page)
+	}
+	var onLast []int
+	for i, cell := range cells {
+		if cell.Page == last {
+			onLast = append(onLast, i)
+		}
+	}
+	n := len(onLast)
+	if n >= g.cellsPerPage() {
+		return cells
+	}
+
+	areaW := float64(g.Cols)*g.CellWidth + float64(g.Cols-1)*g.GutterX
+	areaH := float64(g.Rows)*g.CellHeight + float64(g.Rows-1)*g.GutterY
+	rows, cols, scale := 0, 0, 0.0
+	for c := 1; c <= n; c++ {
+		r := (n + c - 1) / c
+		s := min((areaW-float64(c-1)*g.GutterX)/(float64(c)*g.CellWidth),
+			(areaH-float64(r-1)*g.GutterY)/(float64(r)*g.CellHeight))
+		if s > scale {
+			rows, cols, scale = r, c, s
+		}
+	}
+	cellW, cellH := g.CellWidth*scale, g.CellHeight*scale
+	blockH := float64(rows)*cellH + float64(rows-1)*g.GutterY
+	top := g.OriginY + (areaH-blockH)/2
+
+	out := slices.Clone(cells)
+	for k, i := range onLast {
+		row, col := k/cols, k%cols
+		inRow := min(cols, n-row*cols)
+		if g.RTL {
+			col = inRow - col - 1
+		}
+		rowW := float64(inRow)*cellW + float64(inRow-1)*g.GutterX
+		out[i] = cellPlacement{
+			Page:    last,
+			Row:     row,
+			Col:     col,
+			RowSpan: 1,
+			ColSpan: 1,
+			X:       g.OriginX + (areaW-rowW)/2 + float64(col)*(cellW+g.GutterX),
+			Y:       top + float64(row)*(cellH+g.GutterY),
+			Width:   cellW,
+			Height:  cellH,
+		}
+	}
+	return out
+}
+
+// Centers the cells of a final row left short by the last items
+func (g gridLayout) justifyLastRow(cells []cellPlacement) []cellPlacement {
+	if len(cells) == 0 {
+		return cells
+	}
+	lastPage, lastRow := 0, 0
+	for _, cell := range cells {
+		if cell.Page > lastPage || (cell.Page == lastPage && cell.Row > lastRow) {
+			lastPage, lastRow = cell.Page, cell.Row
+		}
+	}
+	n := 0
+	for _, cell := range cells {
+		if cell.Page == lastPage && cell.Row == lastRow {
+			n++
+		}
+	}
+	shift := float64(g.Cols-n) * (g.CellWidth + g.GutterX) / 2
+	if g.RTL {
+		shift = -shift
+	}
+
+	out := slices.Clone(cells)
+	for i, cell := range out {
+		if cell.Page == lastPage && cell.Row == lastRow {
+			out[i].X += shift
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// A4 landscape without relying on the paper size table
+var testPage = pageSpec{Size: "A4", Orientation: "L", Width: 297, Height: 210}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestNewGridLayout(t *testing.T) {
+	tests := []struct {
+		name             string
+		rows, cols       int
+		cellW, cellH     float64
+		wantW, wantH     float64
+		originX, originY float64
+	}{
+		{"stretched", 2, 4, 0, 0, (297 - 2*pageMarginXMM) / 4, (210 - 2*pageMarginYMM) / 2, pageMarginXMM, pageMarginYMM},
+		{"fixed cells centered", 2, 3, 50, 80, 50, 80, (297 - 150) / 2.0, (210 - 160) / 2.0},
+		{"half fixed stretches", 3, 3, 50, 0, (297 - 2*pageMarginXMM) / 3, (210 - 2*pageMarginYMM) / 3, pageMarginXMM, pageMarginYMM},
+	}
+	for _, tt := range tests {
+		g := newGridLayout(testPage, tt.rows, tt.cols, tt.cellW, tt.cellH)
+		if g.Rows != tt.rows || g.Cols != tt.cols {
+			t.Errorf("%s: grid %dx%d, want %dx%d", tt.name, g.Rows, g.Cols, tt.rows, tt.cols)
+		}
+		if !approx(g.CellWidth, tt.wantW) || !approx(g.CellHeight, tt.wantH) {
+			t.Errorf("%s: cell %gx%g, want %gx%g", tt.name, g.CellWidth, g.CellHeight, tt.wantW, tt.wantH)
+		}
+		if !approx(g.OriginX, tt.originX) || !approx(g.OriginY, tt.originY) {
+			t.Errorf("%s: origin %g,%g, want %g,%g", tt.name, g.OriginX, g.OriginY, tt.originX, tt.originY)
+		}
+	}
+}
+
+func TestGridPageCount(t *testing.T) {
+	g := gridLayout{Rows: 3, Cols: 4}
+	tests := []struct {
+		items, pages int
+	}{
+		{0, 0},
+		{1, 1},
+		{11, 1},
+		{12, 1}, // exactly one full page
+		{13, 2}, // one cell spills over
+		{24, 2},
+		{25, 3},
+	}
+	for _, tt := range tests {
+		if got := g.pageCount(tt.items); got != tt.pages {
+			t.Errorf("pageCount(%d) = %d, want %d", tt.items, got, tt.pages)
+		}
+	}
+}
+
+func TestGridPlace(t *testing.T) {
+	base := gridLayout{Rows: 2, Cols: 3, CellWidth: 50, CellHeight: 80, OriginX: 10, OriginY: 20}
+	rtl := base
+	rtl.RTL = true
+	gutters := base
+	gutters.GutterX, gutters.GutterY = 5, 4
+
+	tests := []struct {
+		name           string
+		grid           gridLayout
+		i              int
+		page, row, col int
+		x, y           float64
+	}{
+		{"first", base, 0, 0, 0, 0, 10, 20},
+		{"end of first row", base, 2, 0, 0, 2, 110, 20},
+		{"next row", base, 3, 0, 1, 0, 10, 100},
+		{"last cell of page", base, 5, 0, 1, 2, 110, 100},
+		{"spills to next page", base, 6, 1, 0, 0, 10, 20},
+		{"rtl first", rtl, 0, 0, 0, 2, 110, 20},
+		{"rtl end of row", rtl, 2, 0, 0, 0, 10, 20},
+		{"rtl spill", rtl, 7, 1, 0, 1, 60, 20},
+		{"gutters", gutters, 4, 0, 1, 1, 10 + 55, 20 + 84},
+		{"gutters last", gutters, 5, 0, 1, 2, 10 + 110, 20 + 84},
+	}
+	for _, tt := range tests {
+		c := tt.grid.place(tt.i)
+		if c.Page != tt.page || c.Row != tt.row || c.Col != tt.col {
+			t.Errorf("%s: place(%d) at page %d row %d col %d, want %d %d %d", tt.name, tt.i, c.Page, c.Row, c.Col, tt.page, tt.row, tt.col)
+		}
+		if !approx(c.X, tt.x) || !approx(c.Y, tt.y) {
+			t.Errorf("%s: place(%d) at %g,%g, want %g,%g", tt.name, tt.i, c.X, c.Y, tt.x, tt.y)
+		}
+		if c.Width != tt.grid.CellWidth || c.Height != tt.grid.CellHeight {
+			t.Errorf("%s: place(%d) is %gx%g, want one cell", tt.name, tt.i, c.Width, c.Height)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var localImageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true}
+
+// Lists the JPEG and PNG files of a directory, sorted by name
+func listImageFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !localImageExts[strings.ToLower(filepath.Ext(entry.Name()))] {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Loads a local image file as a cover, captioned with its file name
+func loadLocalItem(path string, opts fetchOptions) coverItem {
+	name := filepath.Base(path)
+	item := coverItem{
+		Code:    name,
+		Caption: strings.TrimSuffix(name, filepath.Ext(name)),
+		Status:  statusNotFound,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return item
+	}
+	return prepareItem(item, data, detectFormat(data), opts)
+}
@@ -10,8 +10,12 @@ import (
 	_ "image/png"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -22,19 +26,77 @@ import (
 
 const (
 	defaultGridSize   = "3x6"
+	defaultPageSize   = "A4"
 	defaultOutputName = "output.pdf"
+	defaultDateFormat = "2006-01-02"
+	clipboardOutput   = "clipboard.pdf"
 	drPrimaryURLFmt   = "https://i.dr.com.tr/cache/500x400-0/originals/%s-1.jpg"
 	drBackupURLFmt    = "https://i.dr.com.tr/cache/500x400-0/originals/%s.jpg"
 	httpUserAgent     = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)"
 	pageMarginXMM     = 20.0
 	pageMarginYMM     = 20.0
+	minReadableCellMM = 15.0
 	cellBorderInsetMM = 2.0
 	contentPaddingMM  = 10.0
 	cellBorderWidth   = 0.3
 	cellBorderGray    = 160
+	cropMarkGapMM     = 0.5
+	cropMarkLengthMM  = 3.0
+	cropMarkWidth     = 0.1
 	httpTimeout       = 15 * time.Second
+	defaultMaxPixels  = 40_000_000
+	minImageBytes     = 32
+	rateLimitRetries  = 3
+	rateLimitBackoff  = 2 * time.Second
+	rateLimitMaxWait  = time.Minute
+
+	httpMaxIdleConns        = 64
+	httpMaxIdleConnsPerHost = 16
+	prefetchWorkers         = 8
+	slowDownloadThreshold   = 5 * time.Second
+	lowMemoryGCPercent      = 20
+	slowDownloadsShown      = 5
+	httpIdleConnTimeout     = 90 * time.Second
 )
 
+var verbose bool
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// Destination of progress and diagnostic messages; -log tees it to a file
+var logOut io.Writer = os.Stdout
+
+func logf(format string, args ...any) {
+	fmt.Fprintf(logOut, format, args...)
+}
+
+func logln(args ...any) {
+	fmt.Fprintln(logOut, args...)
+}
+
+// Prints diagnostics only when -verbose is set
+func debugf(format string, args ...any) {
+	if verbose {
+		logf(format, args...)
+	}
+}
+
+// Opens the -log file for appending and tees all messages into it
+func openLog(filename string) (*os.File, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(f, "=== %s %s\n", time.Now().Format(time.RFC3339), strings.Join(os.Args, " "))
+	logOut = io.MultiWriter(logOut, ansiStripper{f})
+	return f, nil
+}
+
 // Converts Turkish characters to ASCII for PDF safety
 func toASCII(s string) string {
 	replacer := strings.NewReplacer(
@@ -56,6 +118,132 @@ func drawAsciiText(pdf *fpdf.Fpdf, x, y, w, h float64, text string) {
 	pdf.CellFormat(w, 5, safeText, "", 0, "C", false, 0, "")
 }
 
+// Draws corner crop marks around a cell frame, within the free space between the frame and the cell edge
+func drawCropMarks(pdf *fpdf.Fpdf, x, y, w, h, spaceX, spaceY float64) {
+	lenX := min(spaceX-cropMarkGapMM, cropMarkLengthMM)
+	lenY := min(spaceY-cropMarkGapMM, cropMarkLengthMM)
+
+	pdf.SetLineWidth(cropMarkWidth)
+	for _, cx := range []float64{x, x + w} {
+		for _, cy := range []float64{y, y + h} {
+			dirX, dirY := 1.0, 1.0
+			if cx == x {
+				dirX = -1
+			}
+			if cy == y {
+				dirY = -1
+			}
+			if lenX > 0 {
+				startX := cx + dirX*cropMarkGapMM
+				pdf.Line(startX, cy, startX+dirX*lenX, cy)
+			}
+			if lenY > 0 {
+				startY := cy + dirY*cropMarkGapMM
+				pdf.Line(cx, startY, cx, startY+dirY*lenY)
+			}
+		}
+	}
+}
+
+// Returns the size of an image scaled to fit inside w x h, keeping its aspect ratio
+// Scales the image to fill the box completely; the overflow is meant to be clipped
+func coverImage(config image.Config, w, h float64) (float64, float64) {
+	aspect := float64(config.Height) / float64(config.Width)
+	displayW := w
+	displayH := displayW * aspect
+
+	if displayH < h {
+		displayH = h
+		displayW = displayH / aspect
+	}
+	return displayW, displayH
+}
+
+// Reports whether the image's shape differs from the box's by more than the
+// given factor, in either direction
+func isExtremeAspect(config image.Config, w, h, limit float64) bool {
+	if limit <= 0 || config.Width <= 0 || config.Height <= 0 || w <= 0 || h <= 0 {
+		return false
+	}
+	r := (float64(config.Width) / float64(config.Height)) / (w / h)
+	return r > limit || 1/r > limit
+}
+
+func fitImage(config image.Config, w, h float64) (float64, float64) {
+	aspect := float64(config.Height) / float64(config.Width)
+	displayW := w
+	displayH := displayW * aspect
+
+	if displayH > h {
+		displayH = h
+		displayW = displayH / aspect
+	}
+	return displayW, displayH
+}
+
+type localImage struct {
+	Data   []byte
+	Format string
+	Config image.Config
+}
+
+func loadLocalImage(filename string) (*localImage, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &localImage{Data: data, Format: detectFormat(data), Config: config}, nil
+}
+
+// Draws a 10 cm scale ruler in the bottom-left margin for checking print scale
+func drawRuler(pdf *fpdf.Fpdf, page pageSpec) {
+	const lengthMM = 100
+	x0 := pageMarginXMM
+	y0 := page.Height - (pageMarginYMM / 2)
+
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.SetLineWidth(0.1)
+	pdf.Line(x0, y0, x0+lengthMM, y0)
+	pdf.SetFont("Arial", "", 5)
+	for mm := 0; mm <= lengthMM; mm++ {
+		tick := 1.0
+		switch {
+		case mm%10 == 0:
+			tick = 3
+			pdf.SetXY(x0+float64(mm)-2, y0+0.5)
+			pdf.CellFormat(4, 2, strconv.Itoa(mm/10), "", 0, "C", false, 0, "")
+		case mm%5 == 0:
+			tick = 2
+		}
+		pdf.Line(x0+float64(mm), y0, x0+float64(mm), y0-tick)
+	}
+	pdf.SetXY(x0+lengthMM+1, y0-1.5)
+	pdf.CellFormat(6, 2, "cm", "", 0, "L", false, 0, "")
+}
+
+// Expands an output name template next to the default output path. The
+// .pdf extension is added when the template does not end with one.
+func expandNameTemplate(template, defaultName string, count, rows, cols int, date string) string {
+	dir := filepath.Dir(defaultName)
+	base := strings.TrimSuffix(filepath.Base(defaultName), filepath.Ext(defaultName))
+
+	name := strings.NewReplacer(
+		"{base}", base,
+		"{date}", strings.ReplaceAll(date, "/", "-"),
+		"{count}", strconv.Itoa(count),
+		"{grid}", fmt.Sprintf("%dx%d", rows, cols),
+	).Replace(template)
+
+	if filepath.Ext(name) == "" {
+		name += ".pdf"
+	}
+	return filepath.Join(dir, name)
+}
+
 func parseGridSize(value string) (int, int, error) {
 	clean := strings.ToLower(strings.TrimSpace(value))
 	parts := strings.Split(clean, "x")
@@ -76,22 +264,247 @@ func parseGridSize(value string) (int, int, error) {
 	return rows, cols, nil
 }
 
-func scanIDs(r io.Reader) ([]string, error) {
+// Splits a comma-separated -format value, keeping the first of repeats
+func parseFormats(value string) ([]string, error) {
+	var formats []string
+	for _, part := range strings.Split(value, ",") {
+		format := strings.ToLower(strings.TrimSpace(part))
+		switch format {
+		case "pdf", "png", "zip":
+		default:
+			return nil, fmt.Errorf("unknown format %q (use pdf, png or zip)", format)
+		}
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+type scanOptions struct {
+	// Only accept digit lines, D&R product links and valid ISBNs
+	Strict bool
+	// Prefix marking comment lines; empty means "#"
+	Comment string
+	// Turn each blank line into an empty code that leaves its cell empty
+	KeepBlanks bool
+	// When set, a first line of the form "== Title ==" is stored here
+	// instead of being read as a code
+	Title *string
+	// When set, receives the source text of each code: its input line,
+	// preceded by a directly preceding "# title" comment
+	Sources map[string]string
+	// When set, receives the cell of each code written with a trailing
+	// "@row,col" or "@page:row,col" position
+	Pins map[string]cellPin
+	// When set with Captions, the group named "caption" (or else the first
+	// group) this matches in a code's input line becomes its caption
+	CaptionPattern *regexp.Regexp
+	Captions       map[string]string
+}
+
+func scanIDs(r io.Reader, opts scanOptions) ([]string, error) {
+	comment := opts.Comment
+	if comment == "" {
+		comment = "#"
+	}
+
 	var validIDs []string
 	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	var title string
+	pinnedAt := make(map[cellPin]string)
 	for scanner.Scan() {
+		lineNo++
 		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
+		if line == "" {
+			if opts.KeepBlanks {
+				validIDs = append(validIDs, "")
+			}
+			title = ""
+			continue
+		}
+		if strings.HasPrefix(line, comment) {
+			title = strings.TrimSpace(strings.TrimPrefix(line, comment))
 			continue
 		}
-		extractedID := extractProductCode(line)
-		if extractedID != "" {
-			validIDs = append(validIDs, extractedID)
+		if opts.Title != nil && len(validIDs) == 0 && *opts.Title == "" {
+			if heading, ok := parseTitleLine(line); ok {
+				*opts.Title = heading
+				continue
+			}
+		}
+
+		var caption string
+		if opts.CaptionPattern != nil {
+			line, caption = cutCaption(opts.CaptionPattern, line)
+		}
+		line, pin, pinned := cutPin(line)
+
+		var code string
+		isbn, isISBN := normalizeISBN(line, opts.Strict)
+		switch {
+		case isbn != "":
+			code = isbn
+		case opts.Strict:
+			var ok bool
+			code, ok = extractStrictCode(line)
+			if !ok && isISBN {
+				return nil, fmt.Errorf("line %d: invalid ISBN checksum %q", lineNo, line)
+			}
+			if !ok {
+				return nil, fmt.Errorf("line %d: unrecognized code %q", lineNo, line)
+			}
+		default:
+			code = extractProductCode(line)
+		}
+		if isISBN && isbn == "" {
+			if code != "" {
+				logf("Line %d: %q fails the ISBN checksum; using it as a plain code\n", lineNo, line)
+			} else {
+				logf("Line %d: %q fails the ISBN checksum; skipped\n", lineNo, line)
+			}
 		}
+		if code != "" && pinned && opts.Pins != nil {
+			if other, taken := pinnedAt[pin]; taken && other != code {
+				return nil, fmt.Errorf("line %d: %s is pinned to %s, already taken by %s", lineNo, code, pin, other)
+			}
+			if prev, seen := opts.Pins[code]; seen && prev != pin {
+				return nil, fmt.Errorf("line %d: %s is already pinned to %s", lineNo, code, prev)
+			}
+			opts.Pins[code] = pin
+			pinnedAt[pin] = code
+		}
+		if code != "" && caption != "" && opts.Captions != nil {
+			opts.Captions[code] = caption
+		}
+		if code != "" {
+			validIDs = append(validIDs, code)
+			if opts.Sources != nil {
+				source := line
+				if title != "" {
+					source = title + "\n" + line
+				}
+				opts.Sources[code] = source
+			}
+		}
+		title = ""
+	}
+
+	// Blank lines at the end of the input would only add empty cells
+	for len(validIDs) > 0 && validIDs[len(validIDs)-1] == "" {
+		validIDs = validIDs[:len(validIDs)-1]
 	}
 	return validIDs, scanner.Err()
 }
 
+// Takes the caption matched by the pattern out of an input line. The code
+// is then read from the group named "code" if the pattern has one, or else
+// from what is left of the line once the match is removed.
+func cutCaption(pattern *regexp.Regexp, line string) (string, string) {
+	m := pattern.FindStringSubmatchIndex(line)
+	if m == nil {
+		return line, ""
+	}
+	group := 1
+	if i := pattern.SubexpIndex("caption"); i > 0 {
+		group = i
+	}
+	var caption string
+	if m[2*group] >= 0 {
+		caption = strings.TrimSpace(line[m[2*group]:m[2*group+1]])
+	}
+	if i := pattern.SubexpIndex("code"); i > 0 && m[2*i] >= 0 {
+		return strings.TrimSpace(line[m[2*i]:m[2*i+1]]), caption
+	}
+	return strings.TrimSpace(line[:m[0]] + line[m[1]:]), caption
+}
+
+// Recognizes the "== Title ==" sheet title marker
+func parseTitleLine(line string) (string, bool) {
+	if !strings.HasPrefix(line, "==") || !strings.HasSuffix(line, "==") || len(line) < 4 {
+		return "", false
+	}
+	title := strings.TrimSpace(line[2 : len(line)-2])
+	return title, title != ""
+}
+
+// Accepts only a digit line or a dr.com.tr link with a product number;
+// anything else is rejected rather than mined for digits. Valid ISBNs are
+// recognized before this by normalizeISBN.
+func extractStrictCode(line string) (string, bool) {
+	if isAllDigits(line) {
+		return line, true
+	}
+	if isDRProductURL(line) {
+		code := extractProductCode(line)
+		return code, code != ""
+	}
+	return "", false
+}
+
+func isDRProductURL(line string) bool {
+	u, err := url.Parse(line)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "dr.com.tr" || strings.HasSuffix(host, ".dr.com.tr")
+}
+
+// Reads codes from a file using the same rules as the main input
+func loadCodes(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return scanIDs(f, scanOptions{})
+}
+
+func loadCodeSet(filename string) (map[string]bool, error) {
+	ids, err := loadCodes(filename)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set, nil
+}
+
+// Drops codes present in the set, returning the kept codes and the skip count
+func excludeCodes(ids []string, set map[string]bool) ([]string, int) {
+	var kept []string
+	skipped := 0
+	for _, id := range ids {
+		if set[id] {
+			skipped++
+			continue
+		}
+		kept = append(kept, id)
+	}
+	return kept, skipped
+}
+
+// Appends codes not already present in base, returning the result and the number added
+func appendNewCodes(base, codes []string) ([]string, int) {
+	seen := make(map[string]bool, len(base))
+	for _, id := range base {
+		seen[id] = true
+	}
+	result := append([]string(nil), base...)
+	for _, id := range codes {
+		if seen[id] && id != "" {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result, len(result) - len(base)
+}
+
 func extractProductCode(line string) string {
 	if isAllDigits(line) {
 		return line
@@ -126,37 +539,163 @@ func isAllDigits(s string) bool {
 	return true
 }
 
-func fetchDRImage(client *http.Client, id string) ([]byte, string, error) {
-	url := fmt.Sprintf(drPrimaryURLFmt, id)
-	data, err := download(client, url)
-	if err == nil {
-		return data, detectFormat(data), nil
+// Shared client whose transport keeps connections to the image host alive
+// and negotiates HTTP/2, so consecutive downloads reuse one connection.
+// maxConns caps the connections to each host (0 is unlimited) and
+// idleTimeout is how long an unused connection is kept.
+func newHTTPClient(maxConns int, idleTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConns = httpMaxIdleConns
+	transport.MaxIdleConnsPerHost = httpMaxIdleConnsPerHost
+	transport.MaxConnsPerHost = maxConns
+	transport.IdleConnTimeout = idleTimeout
+	return &http.Client{Timeout: httpTimeout, Transport: transport}
+}
+
+type fetcher struct {
+	client *http.Client
+	// Extra headers, such as credentials for gated hosts, sent with
+	// every image request
+	header http.Header
+	source imageSource
+	cache  *diskCache
+	// Largest accepted response body; 0 means unlimited
+	maxBytes int64
+	// Per-host request pacing; nil sends requests as fast as they come
+	limits *hostLimiter
+}
+
+// Returns the image data, its format and the URL it was served from.
+// Cached copies are preferred over the network in the same fallback order.
+func (f *fetcher) fetchImage(id string) ([]byte, string, string, error) {
+	return f.fetchFrom(f.source.imageURLs(id))
+}
+
+// Fetches the first of the URLs that serves an image, cached copies first
+func (f *fetcher) fetchFrom(urls []string) ([]byte, string, string, error) {
+	for _, rawURL := range urls {
+		if data, ok := f.cache.load(rawURL); ok {
+			debugf("Cache hit: %s\n", rawURL)
+			return data, detectFormat(data), rawURL, nil
+		}
 	}
 
-	urlBackup := fmt.Sprintf(drBackupURLFmt, id)
-	data, err = download(client, urlBackup)
-	if err == nil {
-		return data, detectFormat(data), nil
+	for _, rawURL := range urls {
+		f.limits.wait(rawURL)
+		start := time.Now()
+		data, err := download(f.client, f.header, rawURL, f.maxBytes)
+		if elapsed := time.Since(start); elapsed > slowDownloadThreshold {
+			debugf("Slow download: %s took %s\n", rawURL, elapsed.Round(time.Millisecond))
+		}
+		if err == nil {
+			if err := f.cache.store(rawURL, data); err != nil {
+				debugf("Unable to cache %s: %v\n", rawURL, err)
+			}
+			return data, detectFormat(data), rawURL, nil
+		}
 	}
 
-	return nil, "", fmt.Errorf("image not found")
+	return nil, "", "", fmt.Errorf("image not found")
 }
 
-func download(client *http.Client, url string) ([]byte, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// Downloads the URL, waiting and retrying when the server answers 429
+func download(client *http.Client, header http.Header, rawURL string, maxBytes int64) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		data, wait, err := downloadOnce(client, header, rawURL, attempt, maxBytes)
+		if wait == 0 || attempt >= rateLimitRetries {
+			return data, err
+		}
+		debugf("Throttled by server, retrying %s in %s\n", rawURL, wait)
+		time.Sleep(wait)
+	}
+}
+
+// Performs a single GET; a non-zero wait means the request was rate limited.
+// Bodies larger than maxBytes are abandoned as soon as the limit is passed.
+func downloadOnce(client *http.Client, header http.Header, rawURL string, attempt int, maxBytes int64) ([]byte, time.Duration, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	req.Header.Set("User-Agent", httpUserAgent)
+	addHeaders(req, header)
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait := retryAfter(resp.Header.Get("Retry-After"), attempt)
+		return nil, wait, fmt.Errorf("status: %d", resp.StatusCode)
 	}
-	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("status: %d", resp.StatusCode)
+		return nil, 0, fmt.Errorf("status: %d", resp.StatusCode)
+	}
+	if maxBytes > 0 && resp.ContentLength > maxBytes {
+		return nil, 0, fmt.Errorf("body too large: %d bytes (limit %d)", resp.ContentLength, maxBytes)
+	}
+	var body io.Reader = resp.Body
+	if maxBytes > 0 {
+		body = io.LimitReader(resp.Body, maxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return nil, 0, fmt.Errorf("body too large: over %d bytes", maxBytes)
+	}
+	if len(data) < minImageBytes {
+		return nil, 0, fmt.Errorf("body too small: %d bytes", len(data))
+	}
+	if looksLikeHTML(data) {
+		return nil, 0, fmt.Errorf("got an HTML page instead of an image (content type %q)", resp.Header.Get("Content-Type"))
+	}
+	return data, 0, nil
+}
+
+// Reports whether the body starts like an HTML document; some CDNs answer
+// 200 with an error page even when an image content type is declared
+func looksLikeHTML(data []byte) bool {
+	head := data[:min(len(data), 512)]
+	head = bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF"))
+	head = bytes.ToLower(bytes.TrimSpace(head))
+	return bytes.HasPrefix(head, []byte("<!doctype html")) ||
+		bytes.HasPrefix(head, []byte("<html")) ||
+		(bytes.HasPrefix(head, []byte("<")) && bytes.Contains(head, []byte("<html")))
+}
+
+// Discards a bounded amount of unread body so the connection can be reused
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	body.Close()
+}
+
+// Parses a Retry-After header given in seconds or as an HTTP date,
+// falling back to exponential backoff when it is absent or invalid
+func retryAfter(value string, attempt int) time.Duration {
+	wait := rateLimitBackoff << attempt
+	if secs, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && secs >= 0 {
+		wait = time.Duration(secs) * time.Second
+	} else if when, err := http.ParseTime(value); err == nil {
+		wait = time.Until(when)
+	}
+	if wait <= 0 {
+		wait = time.Second
+	}
+	if wait > rateLimitMaxWait {
+		wait = rateLimitMaxWait
+	}
+	return wait
+}
+
+// Guards against decompression bombs that declare huge dimensions in a tiny file
+func exceedsPixelLimit(config image.Config, limit int) bool {
+	if limit <= 0 {
+		return false
 	}
-	return io.ReadAll(resp.Body)
+	return int64(config.Width)*int64(config.Height) > int64(limit)
 }
 
 func detectFormat(data []byte) string {
@@ -175,139 +714,973 @@ func detectFormat(data []byte) string {
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [input_file]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [input_file | image_dir | list_url]\n\n", os.Args[0])
 		fmt.Fprintln(os.Stderr, "Downloads D&R cover images and renders them on an A4 PDF grid.")
 		fmt.Fprintln(os.Stderr, "\nDetails:")
 		fmt.Fprintln(os.Stderr, "  - Output: Input filename is reused with .pdf extension.")
 		fmt.Fprintln(os.Stderr, "  - Stdin: When no file argument is provided, reads stdin and writes output.pdf.")
+		fmt.Fprintln(os.Stderr, "  - Clipboard: -clipboard reads the pasted list and writes clipboard.pdf.")
+		fmt.Fprintln(os.Stderr, "  - Directory: A directory argument lays out its JPEG/PNG files offline, captioned by file name.")
+		fmt.Fprintln(os.Stderr, "  - URL: An http(s) argument downloads a plain-text list; -scan-html also accepts web pages.")
 		fmt.Fprintln(os.Stderr, "  - Text: All strings are converted to ASCII for PDF rendering.")
-		fmt.Fprintln(os.Stderr, "  - Comments: Lines starting with '#' are ignored.")
+		fmt.Fprintln(os.Stderr, "  - Comments: Lines starting with '#' (or -comment-char) are ignored.")
+		fmt.Fprintln(os.Stderr, "  - Environment: KAPAK_CACHE and KAPAK_SOURCE set the defaults of -cache and -source.")
 		fmt.Fprintln(os.Stderr, "\nExamples:")
 		fmt.Fprintln(os.Stderr, "  go run . books.txt      -> books.pdf")
 		fmt.Fprintln(os.Stderr, "  cat links.txt | go run . -> output.pdf")
+		fmt.Fprintln(os.Stderr, "  go run . -check books.txt -> availability table, no PDF")
+		fmt.Fprintln(os.Stderr, "  go run . -selftest        -> is the image source reachable?")
+		fmt.Fprintln(os.Stderr, "  go run . -diff old.txt books.txt -> codes added and removed since old.txt")
+		fmt.Fprintln(os.Stderr, "  go run . -cell 40x60 -auto-page books.txt -> fewest pages for 40x60 mm covers")
+		fmt.Fprintln(os.Stderr, "  go run . -name-template 'covers-{date}-{count}items' books.txt -> covers-2024-05-01-18items.pdf")
 		flag.PrintDefaults()
 	}
 
 	sizeFlag := flag.String("size", defaultGridSize, "Grid size as rowxcol (e.g., 3x6)")
+	cellFlag := flag.String("cell", "", "Cell size as widthxheight in mm (e.g., 40x60); overrides -size")
+	autoPageFlag := flag.Bool("auto-page", false, "With -cell, pick the page size and orientation that needs the fewest pages")
+	presetFlag := flag.String("preset", "", "Named sheet layout, e.g. a label sheet; overrides -size and -cell ("+strings.Join(presetNames(), ", ")+")")
+	aspectFlag := flag.String("aspect", "", "Cell aspect hint: portrait, square, landscape or a width:height ratio")
+	maxPixelsFlag := flag.Int("max-pixels", defaultMaxPixels, "Reject images whose declared width*height exceeds this (0 disables)")
+	cropMarksFlag := flag.Bool("crop-marks", false, "Draw corner crop marks around each cell")
+	placeholderFlag := flag.String("placeholder", "", "Image shown in cells whose cover could not be fetched")
+	maxWidthFlag := flag.Int("max-width", 0, "Downscale covers wider than this many pixels (0 keeps originals)")
+	resampleFlag := flag.String("resample", defaultResample, "Resampling filter used when downscaling: nearest, bilinear or catmullrom")
+	rtlFlag := flag.Bool("rtl", false, "Fill cells right to left and right-align captions")
+	rulerFlag := flag.Bool("ruler", false, "Draw a 10 cm ruler in the bottom margin to verify print scale")
+	nameTemplateFlag := flag.String("name-template", "", "Output name template with {base}, {date}, {count} and {grid} placeholders")
+	duplicatesFlag := flag.Bool("find-duplicates", false, "Report codes that share an identical cover image")
+	noPromptFlag := flag.Bool("no-prompt", false, "Do not print the stdin prompt when reading from a terminal")
+	maxSizeFlag := flag.Int("max-size", 0, "Lower JPEG quality until the PDF is at most this many bytes (0 disables)")
+	metaTitleFlag := flag.String("meta-title", "", "PDF title (default: derived from the input name)")
+	metaAuthorFlag := flag.String("meta-author", "", "PDF author")
+	metaSubjectFlag := flag.String("meta-subject", "", "PDF subject (default: code count and input name)")
+	metaKeywordsFlag := flag.String("meta-keywords", "", "PDF keywords (default: covers and the image source)")
+	shadowFlag := flag.Bool("shadow", false, "Draw a soft drop shadow behind each cover")
+	shadowOffsetFlag := flag.Float64("shadow-offset", defaultShadowOffset, "Drop shadow offset in mm")
+	shadowBlurFlag := flag.Float64("shadow-blur", defaultShadowBlur, "Drop shadow softness in mm")
+	statusBordersFlag := flag.Bool("status-borders", false, "Color cell borders by result: green found, red not found, orange invalid")
+	minFontSizeFlag := flag.Float64("min-font-size", defaultMinFontSize, "Smallest caption font size in points; longer captions are truncated")
+	strictCodesFlag := flag.Bool("strict-codes", false, "Reject input lines that are not a code, a D&R product link or a valid ISBN; plain 10-digit lines are then read as ISBN-10")
+	colorFlag := flag.String("color", "auto", "Colorize progress output: always, auto or never (auto respects NO_COLOR)")
+	sharpenFlag := flag.Float64("sharpen", 0, "Unsharp mask strength applied to downscaled covers (e.g., 0.5; 0 disables)")
+	flipFlag := flag.String("flip", "", "Mirror every cover: h, v or both")
+	clipboardFlag := flag.Bool("clipboard", false, "Read codes from the system clipboard instead of a file or stdin")
+	onePageFlag := flag.Bool("one-page", false, "Size the grid so that all codes fit on a single page; overrides -size")
+	baselineFlag := flag.Bool("baseline-jpeg", false, "Re-encode progressive JPEGs as baseline before embedding")
+	groupMissesFlag := flag.Bool("group-misses", false, "Collect codes that failed on separate pages after all covers")
+	var headers headerList
+	flag.Var(&headers, "header", "Extra \"Key: Value\" header sent with every image request; repeatable")
+	var sourceRates rateList
+	flag.Var(&sourceRates, "source-rate", "Most requests per second sent to a source or host, as NAME=N (0 lifts the limit); repeatable")
+	selftestFlag := flag.Bool("selftest", false, "Fetch known-good sample codes to test the source, without building a PDF")
+	layoutFlag := flag.String("layout", "", "File of \"code rowxcol\" lines letting covers span several grid cells")
+	urlTemplateFlag := flag.String("url-template", "", "Cover URL with %s in place of the code; overrides the source's URLs")
+	backupURLTemplateFlag := flag.String("backup-url-template", "", "URL tried when -url-template fails, also with %s for the code")
+	formatFlag := flag.String("format", "pdf", "Comma-separated output formats: pdf, png contact sheets, zip of the cover files")
+	maxBytesFlag := flag.Int64("max-bytes", 0, "Treat image downloads larger than this many bytes as failures (0 disables)")
+	masonryFlag := flag.Bool("masonry", false, "Stack covers at their own aspect ratio in the grid's columns instead of fixed cells")
+	prefetchFlag := flag.Bool("prefetch", false, "Check all codes up front, list the misses and render only the available ones")
+	yesFlag := flag.Bool("yes", false, "With -prefetch, continue without asking for confirmation")
+	pageBGFlag := flag.String("page-bg", "", "Page background color as #RRGGBB")
+	pageBGImageFlag := flag.String("page-bg-image", "", "Image stretched over every page behind the grid")
+	annotationsFlag := flag.Bool("annotations", false, "Attach each code's input line as a hover note on its cell")
+	tsvFlag := flag.Bool("tsv", false, "Read the input as a tab-separated export")
+	codeColumnFlag := flag.String("code-column", "", "With -tsv, header name or 1-based number of the code column (default: first)")
+	captionColumnFlag := flag.String("caption-column", "", "With -tsv, header name or 1-based number of a column used as caption")
+	showCodesFlag := flag.Bool("show-codes", false, "Print the code in a corner of every found cover, not only on failures")
+	commentCharFlag := flag.String("comment-char", "#", "Prefix that marks comment lines in the input (e.g. //, ;)")
+	keepBlanksFlag := flag.Bool("keep-blanks", false, "Leave an empty cell for each blank input line")
+	thumbIndexFlag := flag.Bool("thumbnail-index", false, "Begin with pages of small thumbnails linking to each cover's page")
+	titleLineFlag := flag.Bool("title-line", false, "Read a first input line like \"== My Catalog ==\" as the sheet title")
+	nupFlag := flag.Int("nup", 1, "Print 2 or 4 scaled copies of every page on each sheet")
+	nupSheetFlag := flag.String("nup-sheet", "", "Sheet size for -nup, e.g. A3 (default: the page size)")
+	diffFlag := flag.String("diff", "", "Older version of the input list; report the codes added and removed since")
+	diffRenderFlag := flag.Bool("diff-render", false, "With -diff, go on to render only the added codes")
+	maxAspectFlag := flag.Float64("max-aspect", 0, "Crop covers to fill the cell when their shape differs from it by more than this factor, e.g. 1.5 (0 never crops)")
+	labelsFlag := flag.String("labels", "", "File of 'code = label' lines whose labels become the captions of those codes")
+	stripFlag := flag.String("strip", "", "Lay out one row or column of small thumbnails per page: row or column; -cell overrides the thumbnail size")
+	placeholderHashesFlag := flag.String("placeholder-hashes", "", "File of SHA-256 hashes of known placeholder images to treat as not found")
+	serveFlag := flag.String("serve", "", "Listen on this address (e.g. :8080) and render code lists POSTed to /render")
+	qrIndexFlag := flag.Bool("qr-index", false, "End with pages of QR codes linking to each code's product page")
+	strictFormatFlag := flag.Bool("strict-format", false, "Decode each image completely and mark truncated or corrupt ones INVALID FORMAT")
+	captionRegexFlag := flag.String("caption-regex", "", "Regular expression whose first capture group in each input line becomes that code's caption")
+	blankBordersFlag := flag.Bool("blank-borders", false, "With -keep-blanks, draw the border of each empty cell to keep the grid visible")
+	dateFormatFlag := flag.String("date-format", defaultDateFormat, "Go time layout for {date} in -name-template, titles and PDF metadata; local time ($TZ) is used")
+	forceAspectFlag := flag.String("force-aspect", "", "Center-crop every cover to this shape before embedding: portrait, square, landscape or width:height")
+	lowMemoryFlag := flag.Bool("low-memory", false, "Lower peak memory on large lists: release covers once embedded and write the PDF straight to disk")
+	captionPositionFlag := flag.String("caption-position", "below", "Where captions go on found covers: below, above or overlay")
+	scanHTMLFlag := flag.Bool("scan-html", false, "Scan the text of an HTML page for codes when the list URL serves a web page instead of plain text")
+	compactEmptyFlag := flag.Bool("compact-empty", false, "Regrid a partly filled last page so its covers grow to fill the page")
+	justifyLastFlag := flag.Bool("justify-last", false, "Center the cells of a partly filled last row instead of leaving them at the start")
+	pagePreviewsFlag := flag.String("page-previews", "", "Also write a small PNG preview of every grid page into this directory")
+	fallbackOutputFlag := flag.String("fallback-output", "", "Directory to save the PDF in when its usual location cannot be written (e.g. /tmp)")
+	reviewFlag := flag.Bool("review", false, "Show each fetched cover as block art and ask whether to keep it before rendering")
+	matWidthFlag := flag.Float64("mat-width", 0, "Width in mm of a filled mat framing each cover inside its cell (0 disables)")
+	matColorFlag := flag.String("mat-color", defaultMatColor, "Mat color as #RRGGBB for -mat-width")
+	compareFlag := flag.String("compare", "", "Render every code twice side by side, once from each of two sources (names or URL templates), e.g. dr,https://example.com/%s.jpg")
+	dpiReportFlag := flag.Bool("dpi-report", false, "List each cover's pixel size and print DPI, flagging those under 150 DPI; -manifest records them too")
+	maxConnsFlag := flag.Int("max-conns", 0, "Most simultaneous connections to each image host (0 is unlimited)")
+	idleTimeoutFlag := flag.Duration("idle-timeout", httpIdleConnTimeout, "How long an idle connection to an image host is kept open for reuse")
+	zebraFlag := flag.String("zebra", "", "Tint alternate rows or cells to help the eye across wide grids: rows or cells")
+	logFlag := flag.String("log", "", "Also append all progress and diagnostic output to this file")
+	denylistFlag := flag.String("denylist", "", "File with codes to skip (same format as the input)")
+	manifestFlag := flag.String("manifest", "", "Write a JSON description of the rendered layout to this file")
+	cacheFlag := flag.String("cache", os.Getenv("KAPAK_CACHE"), "Directory for caching downloaded images; defaults to $KAPAK_CACHE")
+	sourceFlag := flag.String("source", envOr("KAPAK_SOURCE", defaultSourceName), "Image source to download covers from; defaults to $KAPAK_SOURCE")
+	flag.BoolVar(&verbose, "verbose", false, "Print diagnostic details while running")
+	appendFlag := flag.String("append-manifest", "", "Re-render the items of a prior manifest followed by the new codes, updating it")
+	fromManifestFlag := flag.String("from-manifest", "", "Re-render a prior -manifest file offline into NAME.pdf, taking every cover from the -cache directory")
+	retryFlag := flag.String("retry", "", "Re-render only the failed codes of a prior -manifest file, into NAME-retry.pdf")
+	checkFlag := flag.Bool("check", false, "Only check which codes have a cover image, without building a PDF")
+	verifyCacheFlag := flag.Bool("verify-cache", false, "Check every image in the -cache directory against its hash, download corrupt ones again and exit")
+	warmFlag := flag.Bool("warm", false, "Only download the covers into the -cache directory, without building a PDF")
 	flag.Parse()
+	started := time.Now()
+
+	// Opened first, so that every later complaint reaches the file too
+	if *logFlag != "" {
+		logFile, err := openLog(*logFlag)
+		if err != nil {
+			logf("Unable to open log file: %v\n", err)
+			os.Exit(1)
+		}
+		defer logFile.Close()
+	}
+
+	if *lowMemoryFlag {
+		if *maxSizeFlag > 0 {
+			logln("-low-memory cannot be combined with -max-size, which renders several times")
+			os.Exit(1)
+		}
+		debug.SetGCPercent(lowMemoryGCPercent)
+	}
 
 	rows, cols, err := parseGridSize(*sizeFlag)
 	if err != nil {
-		fmt.Printf("Invalid grid size: %v\n", err)
+		logf("Invalid grid size: %v\n", err)
 		os.Exit(1)
 	}
 
+	var cellW, cellH float64
+	if *cellFlag != "" {
+		cellW, cellH, err = parseCellSize(*cellFlag)
+		if err != nil {
+			logf("Invalid cell size: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *autoPageFlag {
+		logln("-auto-page requires -cell")
+		os.Exit(1)
+	}
+
+	useColor, err = resolveColorMode(*colorFlag)
+	if err != nil {
+		logf("Invalid color mode: %v\n", err)
+		os.Exit(1)
+	}
+
+	var preset *layoutPreset
+	if *presetFlag != "" {
+		if *autoPageFlag {
+			logln("-auto-page cannot be combined with -preset")
+			os.Exit(1)
+		}
+		p, err := lookupPreset(*presetFlag)
+		if err != nil {
+			logf("Invalid preset: %v\n", err)
+			os.Exit(1)
+		}
+		preset = &p
+	}
+
+	if *stripFlag != "" && (preset != nil || *autoPageFlag || *onePageFlag) {
+		logln("-strip cannot be combined with -preset, -auto-page or -one-page")
+		os.Exit(1)
+	}
+
+	resampler, err := lookupResampler(*resampleFlag)
+	if err != nil {
+		logf("Invalid resample filter: %v\n", err)
+		os.Exit(1)
+	}
+	zebra, err := parseZebra(*zebraFlag)
+	if err != nil {
+		logf("Invalid zebra: %v\n", err)
+		os.Exit(1)
+	}
+	captionPos, err := parseCaptionPosition(*captionPositionFlag)
+	if err != nil {
+		logf("Invalid caption position: %v\n", err)
+		os.Exit(1)
+	}
+	mirror, err := parseFlip(*flipFlag)
+	if err != nil {
+		logf("Invalid flip: %v\n", err)
+		os.Exit(1)
+	}
+	forceAspect, err := parseAspect(*forceAspectFlag)
+	if err != nil {
+		logf("Invalid force aspect: %v\n", err)
+		os.Exit(1)
+	}
+	imgOpts := imageOptions{MaxWidth: *maxWidthFlag, Resample: resampler, Sharpen: *sharpenFlag, Flip: mirror, Baseline: *baselineFlag, ForceAspect: forceAspect}
+
+	formats, err := parseFormats(*formatFlag)
+	if err != nil {
+		logf("Invalid format: %v\n", err)
+		os.Exit(1)
+	}
+	if *dpiReportFlag && !slices.Contains(formats, "pdf") {
+		logln("-dpi-report measures the covers as placed in the PDF; add pdf to -format")
+		os.Exit(1)
+	}
+	if (*manifestFlag != "" || *appendFlag != "") && !slices.Contains(formats, "pdf") {
+		logln("-manifest and -append-manifest describe the PDF layout; add pdf to -format")
+		os.Exit(1)
+	}
+
+	aspectRatio, err := parseAspect(*aspectFlag)
+	if err != nil {
+		logf("Invalid aspect: %v\n", err)
+		os.Exit(1)
+	}
+
+	source, err := lookupSource(*sourceFlag)
+	if err != nil {
+		logf("Invalid source: %v\n", err)
+		os.Exit(1)
+	}
+	if *urlTemplateFlag != "" {
+		source, err = source.withTemplates(*urlTemplateFlag, *backupURLTemplateFlag)
+		if err != nil {
+			logf("Invalid URL template: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *backupURLTemplateFlag != "" {
+		logln("-backup-url-template needs -url-template")
+		os.Exit(1)
+	}
+
+	if *maxConnsFlag < 0 || *idleTimeoutFlag < 0 {
+		logln("Invalid connection settings: -max-conns and -idle-timeout must not be negative")
+		os.Exit(1)
+	}
+	client := newHTTPClient(*maxConnsFlag, *idleTimeoutFlag)
+	fetch := &fetcher{client: client, header: headers.header, source: source, maxBytes: *maxBytesFlag}
+	fetch.limits = newHostLimiter([]imageSource{source}, sourceRates.rates)
+	var compareSources [2]imageSource
+	if *compareFlag != "" {
+		compareSources, err = parseCompare(*compareFlag)
+		if err != nil {
+			logf("Invalid compare: %v\n", err)
+			os.Exit(1)
+		}
+		fetch.limits = newHostLimiter(compareSources[:], sourceRates.rates)
+	}
+	if *cacheFlag != "" {
+		fetch.cache, err = newDiskCache(*cacheFlag)
+		if err != nil {
+			logf("Unable to use cache directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *selftestFlag {
+		if !runSelftest(fetch, logOut) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *verifyCacheFlag {
+		if fetch.cache == nil {
+			logln("-verify-cache needs a cache directory; set -cache or KAPAK_CACHE")
+			os.Exit(1)
+		}
+		stats, err := verifyCache(fetch)
+		if err != nil {
+			logf("Unable to verify cache: %v\n", err)
+			os.Exit(1)
+		}
+		logf("Cache verified: %d entries checked, %d repaired, %d removed.\n", stats.Checked, stats.Repaired, stats.Dropped)
+		return
+	}
+
+	if *serveFlag != "" {
+		if err := runServer(*serveFlag, fetch, fetchOptions{MaxPixels: *maxPixelsFlag, Image: imgOpts}); err != nil {
+			logf("Server stopped: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var reader io.Reader
 	var sourceName string
 	var outputName string
 
+	var localDir string
+
 	if flag.NArg() > 0 {
+		if info, err := os.Stat(flag.Arg(0)); err == nil && info.IsDir() {
+			localDir = flag.Arg(0)
+		}
+	}
+
+	if *clipboardFlag && flag.NArg() > 0 {
+		logln("Invalid input: -clipboard does not take a file argument")
+		os.Exit(1)
+	}
+
+	if *retryFlag != "" && (localDir != "" || *clipboardFlag || flag.NArg() > 0) {
+		logln("Invalid input: -retry reads its codes from the manifest and takes no other input")
+		os.Exit(1)
+	}
+
+	var replay *manifest
+	if *fromManifestFlag != "" && (*retryFlag != "" || localDir != "" || *clipboardFlag || flag.NArg() > 0) {
+		logln("Invalid input: -from-manifest reads its codes from the manifest and takes no other input")
+		os.Exit(1)
+	}
+	if *fromManifestFlag != "" && fetch.cache == nil {
+		logln("-from-manifest needs the cache of the prior run; set -cache or KAPAK_CACHE")
+		os.Exit(1)
+	}
+
+	if *fromManifestFlag != "" {
+		replay, err = readManifest(*fromManifestFlag)
+		if err != nil {
+			logf("Unable to read manifest: %v\n", err)
+			os.Exit(1)
+		}
+		sourceName = *fromManifestFlag
+		outputName = strings.TrimSuffix(*fromManifestFlag, filepath.Ext(*fromManifestFlag)) + ".pdf"
+	} else if *retryFlag != "" {
+		prior, err := readManifest(*retryFlag)
+		if err != nil {
+			logf("Unable to read manifest: %v\n", err)
+			os.Exit(1)
+		}
+		failed := prior.failedCodes()
+		if len(failed) == 0 {
+			logf("No failed codes in %s.\n", *retryFlag)
+			return
+		}
+		logf("Retrying %d failed codes of %d from %s.\n", len(failed), len(prior.Items), *retryFlag)
+		reader = strings.NewReader(strings.Join(failed, "\n"))
+		sourceName = *retryFlag
+		outputName = strings.TrimSuffix(*retryFlag, filepath.Ext(*retryFlag)) + "-retry.pdf"
+	} else if localDir != "" {
+		sourceName = localDir
+		outputName = filepath.Clean(localDir) + ".pdf"
+	} else if *clipboardFlag {
+		text, err := readClipboard()
+		if err != nil {
+			logf("Unable to read clipboard: %v\n", err)
+			os.Exit(1)
+		}
+		reader = strings.NewReader(text)
+		sourceName = "clipboard"
+		outputName = clipboardOutput
+	} else if flag.NArg() > 0 && isRemoteList(flag.Arg(0)) {
+		text, err := fetchRemoteList(fetch.client, flag.Arg(0), *scanHTMLFlag)
+		if err != nil {
+			logf("Unable to fetch list: %v\n", err)
+			os.Exit(1)
+		}
+		reader = strings.NewReader(text)
+		sourceName = flag.Arg(0)
+		outputName = remoteOutputName(flag.Arg(0))
+	} else if flag.NArg() > 0 {
 		filename := flag.Arg(0)
 		f, err := os.Open(filename)
 		if err != nil {
-			fmt.Printf("Unable to open file: %v\n", err)
+			logf("Unable to open file: %v\n", err)
 			os.Exit(1)
 		}
 		defer f.Close()
 		reader = f
 		sourceName = filename
 
-		ext := filepath.Ext(filename)
-		outputName = filename[0:len(filename)-len(ext)] + ".pdf"
+		base := strings.TrimSuffix(filename, ".gz")
+		ext := filepath.Ext(base)
+		outputName = base[0:len(base)-len(ext)] + ".pdf"
 	} else {
 		stat, _ := os.Stdin.Stat()
-		if (stat.Mode() & os.ModeCharDevice) != 0 {
-			fmt.Println("Awaiting stdin input... (CTRL+D to finish)")
+		if !*noPromptFlag && (stat.Mode()&os.ModeCharDevice) != 0 {
+			fmt.Fprintln(os.Stderr, "Awaiting stdin input... (CTRL+D to finish)")
 		}
 		reader = os.Stdin
 		sourceName = "stdin"
 		outputName = defaultOutputName
 	}
 
-	ids, err := scanIDs(reader)
+	input := reader
+	if reader != nil {
+		input, err = maybeGunzip(reader)
+		if err != nil {
+			logf("Unable to read input: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var ids []string
+	sources := make(map[string]string)
+	pins := make(map[string]cellPin)
+	var captions map[string]string
+	var sheetTitle string
+	var titlePtr *string
+	if *titleLineFlag {
+		titlePtr = &sheetTitle
+	}
+	if replay != nil {
+		ids, captions = replay.codes(), replay.captions()
+	} else if localDir != "" {
+		ids, err = listImageFiles(localDir)
+	} else if *tsvFlag {
+		ids, captions, err = scanTable(input, tableOptions{
+			Comma:         '\t',
+			CodeColumn:    *codeColumnFlag,
+			CaptionColumn: *captionColumnFlag,
+			Strict:        *strictCodesFlag,
+		})
+	} else {
+		var pattern *regexp.Regexp
+		if *captionRegexFlag != "" {
+			pattern, err = regexp.Compile(*captionRegexFlag)
+			if err == nil && pattern.NumSubexp() == 0 {
+				err = fmt.Errorf("pattern needs a capture group")
+			}
+			if err != nil {
+				logf("Invalid caption regex: %v\n", err)
+				os.Exit(1)
+			}
+			captions = make(map[string]string)
+		}
+		ids, err = scanIDs(input, scanOptions{
+			Strict:     *strictCodesFlag,
+			Comment:    *commentCharFlag,
+			KeepBlanks: *keepBlanksFlag,
+			Sources:    sources,
+			Pins:       pins,
+			Title:      titlePtr,
+
+			CaptionPattern: pattern,
+			Captions:       captions,
+		})
+	}
 	if err != nil {
-		fmt.Printf("Read error: %v\n", err)
-		return
+		logf("Read error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *diffFlag != "" {
+		if localDir != "" {
+			logln("-diff needs code lists, not a directory of images")
+			os.Exit(1)
+		}
+		old, err := loadCodes(*diffFlag)
+		if err != nil {
+			logf("Unable to read old list: %v\n", err)
+			os.Exit(1)
+		}
+		added, removed := diffCodes(old, ids)
+		reportDiff(logOut, added, removed)
+		if !*diffRenderFlag {
+			return
+		}
+		ids = added
+	}
+
+	if *denylistFlag != "" {
+		denied, err := loadCodeSet(*denylistFlag)
+		if err != nil {
+			logf("Unable to read denylist: %v\n", err)
+			os.Exit(1)
+		}
+		var skipped int
+		ids, skipped = excludeCodes(ids, denied)
+		if skipped > 0 {
+			logf("Skipped %d codes listed in denylist.\n", skipped)
+		}
+	}
+
+	if *appendFlag != "" {
+		prior, err := readManifest(*appendFlag)
+		if err != nil {
+			logf("Unable to read manifest: %v\n", err)
+			os.Exit(1)
+		}
+		priorCodes := prior.codes()
+		var added int
+		ids, added = appendNewCodes(priorCodes, ids)
+		logf("Appending %d new codes after %d from %s.\n", added, len(priorCodes), *appendFlag)
+		if *manifestFlag == "" {
+			*manifestFlag = *appendFlag
+		}
 	}
 
 	if len(ids) == 0 {
-		fmt.Println("No valid product code detected.")
+		logln("No valid product code detected.")
 		return
 	}
 
-	fmt.Printf("Source: %s | Target: %s | %d codes will be processed.\n", sourceName, outputName, len(ids))
+	if *checkFlag {
+		if localDir != "" {
+			logln("-check needs product codes, not a directory of images")
+			os.Exit(1)
+		}
+		logf("Source: %s | %d codes will be checked.\n", sourceName, len(ids))
+		runCheck(fetch, ids, logOut)
+		return
+	}
 
-	pdf := fpdf.New("L", "mm", "A4", "")
-	pdf.SetFont("Arial", "", 12)
-	pdf.AddPage()
+	if replay != nil && (*prefetchFlag || *compareFlag != "" || *warmFlag) {
+		logln("-from-manifest renders offline and cannot be combined with -prefetch, -compare or -warm")
+		os.Exit(1)
+	}
+
+	if *warmFlag {
+		if localDir != "" {
+			logln("-warm needs product codes, not a directory of images")
+			os.Exit(1)
+		}
+		if fetch.cache == nil {
+			logln("-warm needs a cache directory; set -cache or KAPAK_CACHE")
+			os.Exit(1)
+		}
+		logf("Source: %s | %d codes will be cached in %s.\n", sourceName, len(ids), *cacheFlag)
+		stats := warmCache(fetch, ids)
+		logf("Cache warmed: %d already cached, %d downloaded (%d bytes), %d missing.\n",
+			stats.Cached, stats.Downloaded, stats.Bytes, stats.Missing)
+		if stats.Cached+stats.Downloaded == 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *compareFlag != "" && (localDir != "" || *prefetchFlag) {
+		logln("-compare downloads from both sources and cannot be combined with an image directory or -prefetch")
+		os.Exit(1)
+	}
+	if *reviewFlag && reader == os.Stdin {
+		logln("Invalid input: -review answers questions on stdin, so codes must come from a file")
+		os.Exit(1)
+	}
+
+	if *prefetchFlag && localDir == "" {
+		logf("Checking %d codes...\n", len(ids))
+		hits, misses := prefetch(fetch, ids)
+		for _, id := range misses {
+			logf("Missing: %s\n", id)
+		}
+		logf("%d/%d codes available.\n", len(hits), len(ids))
+		if len(hits) == 0 {
+			os.Exit(1)
+		}
+		if len(misses) > 0 && !*yesFlag {
+			if reader == os.Stdin {
+				logln("Codes were read from stdin; pass -yes to continue without confirmation.")
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "Render the %d available codes? [y/N] ", len(hits))
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+				logln("Aborted.")
+				os.Exit(1)
+			}
+		}
+		ids = hits
+	}
+
+	// Built only now so that both inherit the finished fetcher, cache included
+	var compared [2]*fetcher
+	if *compareFlag != "" {
+		compared = compareFetchers(fetch, compareSources)
+		ids = pairIDs(ids)
+	}
+
+	page := newPageSpec(defaultPageSize, "L")
+	var grid gridLayout
+	switch {
+	case preset != nil:
+		page = preset.Page
+		grid = preset.grid()
+	case *stripFlag != "":
+		page, grid, err = stripGrid(*stripFlag, cellW, cellH)
+		if err != nil {
+			logf("Invalid strip: %v\n", err)
+			os.Exit(1)
+		}
+	case *autoPageFlag:
+		page, rows, cols, err = chooseAutoPage(len(ids), cellW, cellH)
+		if err != nil {
+			logln(err)
+			os.Exit(1)
+		}
+		grid = newGridLayout(page, rows, cols, cellW, cellH)
+	case *onePageFlag:
+		rows, cols = gridForCount(page, len(ids))
+		grid = newGridLayout(page, rows, cols, 0, 0)
+		if min(grid.CellWidth, grid.CellHeight) < minReadableCellMM {
+			logf("Warning: %d codes on one page leaves %.0fx%.0f mm cells; covers may be unreadable.\n", len(ids), grid.CellWidth, grid.CellHeight)
+		}
+	case cellW > 0:
+		rows, cols = gridForCell(page, cellW, cellH)
+		if rows <= 0 || cols <= 0 {
+			logf("Cell size %gx%g mm does not fit on %s.\n", cellW, cellH, page)
+			os.Exit(1)
+		}
+		grid = newGridLayout(page, rows, cols, cellW, cellH)
+	default:
+		grid = newGridLayout(page, rows, cols, 0, 0)
+	}
 
-	width, height := pdf.GetPageSize()
+	if *nameTemplateFlag != "" {
+		outputName = expandNameTemplate(*nameTemplateFlag, outputName, len(ids), grid.Rows, grid.Cols, started.Format(*dateFormatFlag))
+	}
+	baseName := strings.TrimSuffix(outputName, ".pdf")
+	var targets []string
+	for _, format := range formats {
+		if format == "pdf" {
+			targets = append(targets, outputName)
+		} else {
+			targets = append(targets, baseName+"."+format)
+		}
+	}
+	wants := func(format string) bool { return slices.Contains(formats, format) }
 
-	cellsPerPage := rows * cols
-	cellWidth := (width - (2 * pageMarginXMM)) / float64(cols)
-	cellHeight := (height - (2 * pageMarginYMM)) / float64(rows)
+	logf("Source: %s | Target: %s | %d codes will be processed.\n", sourceName, strings.Join(targets, ", "), len(ids))
+	switch {
+	case preset != nil:
+		logf("Preset: %s | Pages: %d\n", preset.Description, grid.pageCount(len(ids)))
+	case *autoPageFlag, *onePageFlag, *stripFlag != "":
+		logf("Page: %s | Grid: %dx%d | Pages: %d\n", page, grid.Rows, grid.Cols, grid.pageCount(len(ids)))
+	}
 
-	client := &http.Client{Timeout: httpTimeout}
+	var labels map[string]string
+	if *labelsFlag != "" {
+		labels, err = loadLabels(*labelsFlag)
+		if err != nil {
+			logf("Invalid labels: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	for i, id := range ids {
-		if i > 0 && i%cellsPerPage == 0 {
-			pdf.AddPage()
+	var spans map[string]cellSpan
+	if *layoutFlag != "" {
+		spans, err = loadSpans(*layoutFlag)
+		if err != nil {
+			logf("Invalid layout: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(pins) > 0 {
+		if len(spans) > 0 || *masonryFlag {
+			logln("Pinned positions cannot be combined with -layout or -masonry")
+			os.Exit(1)
+		}
+		if err := checkPins(pins, grid); err != nil {
+			logf("Invalid pin: %v\n", err)
+			os.Exit(1)
 		}
+	}
+	if (*compactEmptyFlag || *justifyLastFlag) && (len(spans) > 0 || *masonryFlag || len(pins) > 0) {
+		logln("-compact-empty and -justify-last cannot be combined with -layout, -masonry or pinned positions")
+		os.Exit(1)
+	}
 
-		pageIndex := i % cellsPerPage
-		row := pageIndex / cols
-		col := pageIndex % cols
+	sheet, err := newImposition(page, *nupSheetFlag, *nupFlag)
+	if err != nil {
+		logf("Invalid imposition: %v\n", err)
+		os.Exit(1)
+	}
+	if *nupFlag > 1 && (*annotationsFlag || *thumbIndexFlag || *qrIndexFlag) {
+		logln("-nup cannot be combined with -annotations, -thumbnail-index or -qr-index")
+		os.Exit(1)
+	}
 
-		x := pageMarginXMM + (float64(col) * cellWidth)
-		y := pageMarginYMM + (float64(row) * cellHeight)
+	if *matWidthFlag < 0 {
+		logln("Invalid mat width: must not be negative")
+		os.Exit(1)
+	}
+	matColor, err := parseHexColor(*matColorFlag)
+	if err != nil {
+		logf("Invalid mat color: %v\n", err)
+		os.Exit(1)
+	}
+	var bgColor *[3]int
+	if *pageBGFlag != "" {
+		c, err := parseHexColor(*pageBGFlag)
+		if err != nil {
+			logf("Invalid page background: %v\n", err)
+			os.Exit(1)
+		}
+		bgColor = &c
+	}
+	var bgImage *localImage
+	if *pageBGImageFlag != "" {
+		bgImage, err = loadLocalImage(*pageBGImageFlag)
+		if err != nil {
+			logf("Unable to load page background image: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-		fmt.Printf("[%02d/%02d] Downloading ID: %s\n", i+1, len(ids), id)
+	var placeholder *localImage
+	if *placeholderFlag != "" {
+		placeholder, err = loadLocalImage(*placeholderFlag)
+		if err != nil {
+			logf("Unable to load placeholder: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-		pdf.SetLineWidth(cellBorderWidth)
-		pdf.SetDrawColor(cellBorderGray, cellBorderGray, cellBorderGray)
-		pdf.Rect(x+cellBorderInsetMM, y+cellBorderInsetMM, cellWidth-(2*cellBorderInsetMM), cellHeight-(2*cellBorderInsetMM), "D")
-		pdf.SetDrawColor(0, 0, 0)
+	grid.RTL = *rtlFlag
 
-		imgData, format, err := fetchDRImage(client, id)
+	fetchOpts := fetchOptions{
+		MaxPixels:  *maxPixelsFlag,
+		Image:      imgOpts,
+		Duplicates: *duplicatesFlag,
 
-		if err == nil && imgData != nil {
-			imgConfig, _, errDecode := image.DecodeConfig(bytes.NewReader(imgData))
-			if errDecode != nil {
-				drawAsciiText(pdf, x, y, cellWidth, cellHeight, "INVALID FORMAT")
+		StrictFormat: *strictFormatFlag,
+	}
+	if *placeholderHashesFlag != "" {
+		fetchOpts.Denylist, err = loadHashSet(*placeholderHashesFlag)
+		if err != nil {
+			logf("Invalid placeholder hashes: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var items []coverItem
+	if replay != nil {
+		items, err = loadManifestItems(replay, ids, fetch.cache, fetchOpts)
+		if err != nil {
+			logf("Unable to render from manifest: %v\n", err)
+			os.Exit(1)
+		}
+	} else if localDir != "" {
+		items = collectItems(ids, "Loading file", func(path string) coverItem {
+			return loadLocalItem(path, fetchOpts)
+		})
+	} else if compared[0] != nil {
+		// Paired codes alternate between the sources; blanks come in pairs too
+		n := 0
+		items = collectItems(ids, "Downloading ID", func(id string) coverItem {
+			f := compared[n%2]
+			n++
+			item := fetchItem(f, id, fetchOpts)
+			item.Origin = f.source.Name
+			return item
+		})
+	} else {
+		items = collectItems(ids, "Downloading ID", func(id string) coverItem {
+			return fetchItem(fetch, id, fetchOpts)
+		})
+	}
+	if verbose && localDir == "" {
+		reportSlowest(items, slowDownloadsShown)
+	}
+	for i := range items {
+		if caption, ok := captions[items[i].Code]; ok {
+			items[i].Caption = caption
+		}
+		if label, ok := labels[items[i].Code]; ok {
+			items[i].Caption = label
+		}
+		if src, ok := sources[items[i].Code]; ok {
+			items[i].Source = src
+		} else {
+			items[i].Source = items[i].Code
+		}
+		if *qrIndexFlag && localDir == "" && items[i].Status != statusBlank {
+			lines := strings.Split(items[i].Source, "\n")
+			items[i].Link = source.productURL(items[i].Code, lines[len(lines)-1])
+		}
+	}
+	if *reviewFlag {
+		var ok bool
+		items, ok = reviewItems(items, bufio.NewReader(os.Stdin), os.Stderr)
+		if !ok {
+			logln("Aborted.")
+			os.Exit(1)
+		}
+		if len(items) == 0 {
+			logln("No covers kept; nothing to render.")
+			os.Exit(1)
+		}
+		logf("Keeping %d of %d cells.\n", len(items), len(ids))
+	}
+	if compared[0] != nil {
+		for i := range items {
+			if items[i].Status == statusBlank {
 				continue
 			}
+			if items[i].Caption != "" {
+				items[i].Caption += " (" + items[i].Origin + ")"
+			} else {
+				items[i].Caption = items[i].Origin
+			}
+		}
+	}
+	dupes := findDuplicates(items)
+
+	if wants("zip") {
+		if err := writeCoverZip(baseName+".zip", items); err != nil {
+			logln("Failed to save ZIP:", err)
+			os.Exit(1)
+		}
+		logf("Success! File saved: %s\n", baseName+".zip")
+	}
 
-			aspect := float64(imgConfig.Height) / float64(imgConfig.Width)
-			displayW := cellWidth - contentPaddingMM
-			displayH := displayW * aspect
+	meta := pdfMetadata{
+		Title:    *metaTitleFlag,
+		Author:   *metaAuthorFlag,
+		Subject:  *metaSubjectFlag,
+		Keywords: *metaKeywordsFlag,
+		Created:  started,
+	}
+	if meta.Title == "" && sheetTitle != "" {
+		meta.Title = sheetTitle
+	}
+	if meta.Title == "" {
+		meta.Title = "Covers: " + filepath.Base(sourceName)
+	}
+	if meta.Subject == "" {
+		meta.Subject = fmt.Sprintf("%d covers from %s", len(ids), sourceName)
+	}
+	if meta.Keywords == "" {
+		meta.Keywords = "covers, " + source.Name
+	}
+	date := started.Format(*dateFormatFlag)
+	meta.Title = strings.ReplaceAll(meta.Title, "{date}", date)
+	meta.Subject = strings.ReplaceAll(meta.Subject, "{date}", date)
+	sheetTitle = strings.ReplaceAll(sheetTitle, "{date}", date)
 
-			if displayH > (cellHeight - contentPaddingMM) {
-				displayH = cellHeight - contentPaddingMM
-				displayW = displayH / aspect
-			}
+	renderOpts := renderOptions{
+		Page:        page,
+		Grid:        grid,
+		Aspect:      aspectRatio,
+		CropMarks:   *cropMarksFlag,
+		Ruler:       *rulerFlag,
+		Placeholder: placeholder,
+		Metadata:    meta,
+		Heading:     sheetTitle,
+		GroupMisses: *groupMissesFlag,
+		Spans:       spans,
+		Pins:        pins,
+		Masonry:     *masonryFlag,
+		MaxAspect:   *maxAspectFlag,
 
-			centerX := x + (cellWidth-displayW)/2
-			centerY := y + (cellHeight-displayH)/2
+		ShowCodes:   *showCodesFlag,
+		Annotations: *annotationsFlag,
 
-			imageName := fmt.Sprintf("img_%d", i)
-			opt := fpdf.ImageOptions{ImageType: format, ReadDpi: true}
+		ThumbnailIndex: *thumbIndexFlag,
+		QRIndex:        *qrIndexFlag,
+		Imposition:     sheet,
 
-			pdf.RegisterImageOptionsReader(imageName, opt, bytes.NewReader(imgData))
-			pdf.ImageOptions(imageName, centerX, centerY, displayW, displayH, false, opt, 0, "")
+		BackgroundColor: bgColor,
+		BackgroundImage: bgImage,
 
-		} else {
-			drawAsciiText(pdf, x, y, cellWidth, cellHeight, "NOT FOUND")
+		StatusBorders: *statusBordersFlag,
+		BlankBorders:  *blankBordersFlag,
+		LowMemory:     *lowMemoryFlag,
+		MinFontSize:   *minFontSizeFlag,
 
-			pdf.SetFont("Arial", "", 8)
-			pdf.SetXY(x, y+cellHeight-contentPaddingMM)
-			safeID := toASCII(id)
-			pdf.CellFormat(cellWidth, 5, safeID, "", 0, "C", false, 0, "")
+		CaptionPosition: captionPos,
+		CompactEmpty:    *compactEmptyFlag,
+		JustifyLast:     *justifyLastFlag,
+		Zebra:           zebra,
+		MatWidth:        *matWidthFlag,
+		MatColor:        matColor,
+
+		Shadow:       *shadowFlag,
+		ShadowOffset: *shadowOffsetFlag,
+		ShadowBlur:   *shadowBlurFlag,
+	}
+
+	if wants("png") {
+		sheets, err := renderPNG(items, renderOpts, 0)
+		if err != nil {
+			logln("Failed to render PNG:", err)
+			os.Exit(1)
+		}
+		for i, sheet := range sheets {
+			name := baseName + ".png"
+			if len(sheets) > 1 {
+				name = fmt.Sprintf("%s-%d.png", baseName, i+1)
+			}
+			if err := os.WriteFile(name, sheet, 0o644); err != nil {
+				logln("Failed to save PNG:", err)
+				os.Exit(1)
+			}
+			logf("Success! File saved: %s\n", name)
+		}
+	}
+	if *pagePreviewsFlag != "" {
+		if err := writePagePreviews(*pagePreviewsFlag, filepath.Base(baseName), items, renderOpts); err != nil {
+			logf("Failed to write page previews: %v\n", err)
+			os.Exit(1)
 		}
 	}
+	if !wants("pdf") {
+		if *duplicatesFlag {
+			dupes.report(logOut)
+		}
+		return
+	}
 
-	if err := pdf.OutputFileAndClose(outputName); err != nil {
-		fmt.Println("Failed to save PDF:", err)
-	} else {
-		fmt.Printf("Success! File saved: %s\n", outputName)
+	var output []byte
+	var layout *manifest
+	var saved string
+	quality := 0
+	switch {
+	case *lowMemoryFlag:
+		var f *os.File
+		f, err = createOutput(outputName, *fallbackOutputFlag)
+		if err != nil {
+			logln("Failed to save PDF:", err)
+			os.Exit(1)
+		}
+		saved = f.Name()
+		layout, err = renderToFile(items, renderOpts, f)
+	case *maxSizeFlag > 0:
+		output, layout, quality, err = renderWithinSize(items, renderOpts, *maxSizeFlag)
+	default:
+		output, layout, err = renderBytes(items, renderOpts)
+	}
+	if err != nil {
+		logln("Failed to render PDF:", err)
+		os.Exit(1)
+	}
+	if quality > 0 && len(output) > *maxSizeFlag {
+		logf("Could not reach %d bytes; lowest JPEG quality %d gives %d bytes.\n", *maxSizeFlag, quality, len(output))
+	} else if quality > 0 {
+		logf("Covers recompressed at JPEG quality %d to fit %d bytes.\n", quality, *maxSizeFlag)
+	}
+
+	if *duplicatesFlag {
+		dupes.report(logOut)
+	}
+	if *dpiReportFlag {
+		layout.reportDPI()
+	}
+
+	if !*lowMemoryFlag {
+		saved, err = writeOutput(outputName, output, *fallbackOutputFlag)
+		if err != nil {
+			logln("Failed to save PDF:", err)
+			os.Exit(1)
+		}
+	}
+	logf("Success! File saved: %s\n", saved)
+
+	// Written last, so that it never describes a PDF that was not saved
+	if *manifestFlag != "" {
+		if err := writeManifest(*manifestFlag, layout); err != nil {
+			logln("Failed to write manifest:", err)
+		} else {
+			logf("Manifest saved: %s\n", *manifestFlag)
+		}
 	}
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Encodes a small gradient as a JPEG, large enough to pass minImageBytes
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Fetcher for a source whose URL templates point at paths of the test server
+func testFetcher(srv *httptest.Server, paths ...string) *fetcher {
+	src := imageSource{Name: "test"}
+	for _, p := range paths {
+		src.URLFmts = append(src.URLFmts, srv.URL+p)
+	}
+	return &fetcher{client: newHTTPClient(0, httpIdleConnTimeout), source: src}
+}
+
+func TestDownloadHonorsRetryAfter(t *testing.T) {
+	img := testJPEG(t, 40, 60)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	data, err := download(srv.Client(), nil, srv.URL+"/1.jpg", 0)
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least the 1s of Retry-After", elapsed)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+	if !bytes.Equal(data, img) {
+		t.Errorf("got %d bytes, want the %d bytes of the second response", len(data), len(img))
+	}
+}
+
+func TestFetchTreatsEmptyBodyAsMiss(t *testing.T) {
+	img := testJPEG(t, 40, 60)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		if r.URL.Path == "/backup/1.jpg" {
+			w.Write(img)
+		}
+	}))
+	defer srv.Close()
+
+	if _, _, _, err := testFetcher(srv, "/%s.jpg").fetchImage("1"); err == nil {
+		t.Error("zero-length 200 counted as an image")
+	}
+
+	data, _, rawURL, err := testFetcher(srv, "/%s.jpg", "/backup/%s.jpg").fetchImage("1")
+	if err != nil {
+		t.Fatalf("no fallback after a zero-length 200: %v", err)
+	}
+	if rawURL != srv.URL+"/backup/1.jpg" || !bytes.Equal(data, img) {
+		t.Errorf("got %d bytes from %s, want the backup image", len(data), rawURL)
+	}
+}
+
+func TestDownloadAbortsOversizedBody(t *testing.T) {
+	const limit, total = 64 << 10, 64 << 20
+	sent := make(chan int, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Streamed without a Content-Length, so only reading can tell
+		w.Header().Set("Content-Type", "image/jpeg")
+		chunk := bytes.Repeat([]byte{0xff}, 32<<10)
+		n := 0
+		for n < total {
+			if _, err := w.Write(chunk); err != nil {
+				break
+			}
+			w.(http.Flusher).Flush()
+			n += len(chunk)
+		}
+		sent <- n
+	}))
+	defer srv.Close()
+
+	_, err := download(srv.Client(), nil, srv.URL+"/1.jpg", limit)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("got error %v, want the body rejected as too large", err)
+	}
+	select {
+	case n := <-sent:
+		if n >= total {
+			t.Errorf("server sent all %d bytes; the download was not aborted", n)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("server still sending; the download was not aborted")
+	}
+}
+
+func TestLooksLikeHTML(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{"<!DOCTYPE html><html><body>Not found</body></html>", true},
+		{"\xEF\xBB\xBF  <html lang=\"tr\">", true},
+		{"<!-- cdn error -->\n<html>", true},
+		{"<?xml version=\"1.0\"?><svg/>", false},
+		{"\xFF\xD8\xFF\xE0 <html>", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := looksLikeHTML([]byte(tt.body)); got != tt.want {
+			t.Errorf("looksLikeHTML(%q) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadRejectsHTMLServedAsImage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("<!DOCTYPE html>\n<html><head><title>404</title></head><body>Ürün bulunamadı</body></html>"))
+	}))
+	defer srv.Close()
+
+	_, err := download(srv.Client(), nil, srv.URL+"/1.jpg", 0)
+	if err == nil || !strings.Contains(err.Error(), "HTML page") {
+		t.Errorf("got error %v, want the HTML page rejected", err)
+	}
+}
+
+func TestDownloadDropsCredentialsOnCrossHostRedirect(t *testing.T) {
+	img := testJPEG(t, 40, 60)
+	var cdnAuth atomic.Value
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cdnAuth.Store(r.Header.Get("Authorization"))
+		w.Write(img)
+	}))
+	defer cdn.Close()
+	// Another host name for the same loopback address
+	cdnURL := strings.Replace(cdn.URL, "127.0.0.1", "localhost", 1)
+
+	var originAuth atomic.Value
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originAuth.Store(r.Header.Get("Authorization"))
+		http.Redirect(w, r, cdnURL+r.URL.Path, http.StatusFound)
+	}))
+	defer origin.Close()
+
+	header := http.Header{"Authorization": {"Bearer secret"}}
+	data, err := download(newHTTPClient(0, httpIdleConnTimeout), header, origin.URL+"/1.jpg", 0)
+	if err != nil || !bytes.Equal(data, img) {
+		t.Fatalf("download through redirect: %v", err)
+	}
+	if got := originAuth.Load(); got != "Bearer secret" {
+		t.Errorf("image host got Authorization %q, want the -header value", got)
+	}
+	if got := cdnAuth.Load(); got != "" {
+		t.Errorf("redirect target on another host got Authorization %q", got)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	statusOK            = "ok"
+	statusNotFound      = "not_found"
+	statusInvalidFormat = "invalid_format"
+	// Empty cell kept for a blank input line (-keep-blanks)
+	statusBlank = "blank"
+)
+
+type manifestPage struct {
+	Size        string  `json:"size"`
+	Orientation string  `json:"orientation"`
+	WidthMM     float64 `json:"width_mm"`
+	HeightMM    float64 `json:"height_mm"`
+}
+
+type manifestGrid struct {
+	Rows         int     `json:"rows"`
+	Cols         int     `json:"cols"`
+	CellWidthMM  float64 `json:"cell_width_mm"`
+	CellHeightMM float64 `json:"cell_height_mm"`
+}
+
+type manifestItem struct {
+	Code   string `json:"code"`
+	Page   int    `json:"page"`
+	Row    int    `json:"row"`
+	Col    int    `json:"col"`
+	Status string `json:"status"`
+	// Set only for covers spanning several cells; Row and Col are the top-left cell
+	RowSpan int    `json:"row_span,omitempty"`
+	ColSpan int    `json:"col_span,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Caption string `json:"caption,omitempty"`
+
+	Hash        string `json:"sha256,omitempty"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+
+	// Embedded image size and the resolution it prints at on the page
+	WidthPx  int     `json:"width_px,omitempty"`
+	HeightPx int     `json:"height_px,omitempty"`
+	PrintDPI float64 `json:"print_dpi,omitempty"`
+	LowDPI   bool    `json:"low_dpi,omitempty"`
+}
+
+// JSON description of a rendered layout; pages, rows and columns are 1-based
+type manifest struct {
+	Page  manifestPage   `json:"page"`
+	Grid  manifestGrid   `json:"grid"`
+	Items []manifestItem `json:"items"`
+}
+
+func writeManifest(filename string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0o644)
+}
+
+func readManifest(filename string) (*manifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Returns the manifest codes in layout order
+func (m *manifest) codes() []string {
+	codes := make([]string, 0, len(m.Items))
+	for _, item := range m.Items {
+		codes = append(codes, item.Code)
+	}
+	return codes
+}
+
+// Returns the codes that were not found or had an invalid image, in layout order
+func (m *manifest) failedCodes() []string {
+	var codes []string
+	for _, item := range m.Items {
+		if item.Status == statusNotFound || item.Status == statusInvalidFormat {
+			codes = append(codes, item.Code)
+		}
+	}
+	return codes
+}
+
+// Lists the print resolution of every embedded cover, marking those below
+// lowPrintDPI
+func (m *manifest) reportDPI() {
+	low, covers := 0, 0
+	logln("Print resolution:")
+	for _, item := range m.Items {
+		if item.PrintDPI == 0 {
+			continue
+		}
+		covers++
+		mark := ""
+		if item.LowDPI {
+			low++
+			mark = " LOW"
+		}
+		logf("  %s: %dx%d px at %.0f DPI%s\n", item.Code, item.WidthPx, item.HeightPx, item.PrintDPI, mark)
+	}
+	logf("%d of %d covers print below %d DPI.\n", low, covers, lowPrintDPI)
+}
+
+// Captions recorded in the manifest, by code
+func (m *manifest) captions() map[string]string {
+	captions := make(map[string]string)
+	for _, item := range m.Items {
+		if item.Caption != "" {
+			captions[item.Code] = item.Caption
+		}
+	}
+	return captions
+}
+
+// Cover items of a prior render built from the cache alone, for the ids
+// taken from the manifest; codes that failed then keep their status.
+// Fails up front, listing every found cover whose image is no longer cached.
+func loadManifestItems(m *manifest, ids []string, cache *diskCache, opts fetchOptions) ([]coverItem, error) {
+	entries := make(map[string]manifestItem)
+	for _, entry := range m.Items {
+		if _, ok := entries[entry.Code]; !ok && entry.Code != "" {
+			entries[entry.Code] = entry
+		}
+	}
+	var missing []string
+	for _, id := range ids {
+		if entry, ok := entries[id]; ok && entry.Status == statusOK && !slices.Contains(missing, id) {
+			if _, ok := cache.load(entry.URL); !ok {
+				missing = append(missing, id)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%d cached images missing: %s", len(missing), strings.Join(missing, ", "))
+	}
+
+	return collectItems(ids, "Loading cached ID", func(id string) coverItem {
+		entry := entries[id]
+		if entry.Status != statusOK {
+			return coverItem{Code: id, Status: entry.Status}
+		}
+		data, _ := cache.load(entry.URL)
+		return prepareItem(coverItem{Code: id, URL: entry.URL, Status: statusNotFound}, data, detectFormat(data), opts)
+	}), nil
+}
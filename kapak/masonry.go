@@ -0,0 +1,79 @@
+package main
+
+// Packs covers into the grid's columns, Pinterest style: each cover keeps
+// its own aspect ratio at the column width and goes below the currently
+// shortest column. A page is full once the next cover fits under no column.
+// Failed items keep the regular cell height.
+func (g gridLayout) placeMasonry(items []coverItem, groupMisses bool) []cellPlacement {
+	order, breakAt := placementOrder(items, groupMisses)
+	areaH := float64(g.Rows)*g.CellHeight + float64(g.Rows-1)*g.GutterY
+
+	placements := make([]cellPlacement, len(items))
+	heights := make([]float64, g.Cols)
+	stacked := make([]int, g.Cols)
+	page := 0
+	newPage := func() {
+		page++
+		clear(heights)
+		clear(stacked)
+	}
+
+	for n, i := range order {
+		if n == breakAt && heights[shortestColumn(heights)] > 0 {
+			newPage()
+		}
+		h := min(masonryHeight(items[i], g.CellWidth, g.CellHeight), areaH)
+
+		col := shortestColumn(heights)
+		if heights[col] > 0 && heights[col]+g.GutterY+h > areaH {
+			newPage()
+			col = 0
+		}
+		top := heights[col]
+		if top > 0 {
+			top += g.GutterY
+		}
+
+		physical := col
+		if g.RTL {
+			physical = g.Cols - 1 - col
+		}
+		placements[i] = cellPlacement{
+			Page:    page,
+			Row:     stacked[col],
+			Col:     physical,
+			RowSpan: 1,
+			ColSpan: 1,
+			X:       g.OriginX + float64(physical)*(g.CellWidth+g.GutterX),
+			Y:       g.OriginY + top,
+			Width:   g.CellWidth,
+			Height:  h,
+		}
+		heights[col] = top + h
+		stacked[col]++
+	}
+	return placements
+}
+
+// Cell height that fits the cover at the full content width, plus its caption
+func masonryHeight(item coverItem, cellW, cellH float64) float64 {
+	if item.Status != statusOK || item.Config.Width <= 0 {
+		return cellH
+	}
+	boxW := cellW - contentPaddingMM
+	h := boxW*float64(item.Config.Height)/float64(item.Config.Width) + contentPaddingMM
+	if item.Caption != "" {
+		h += captionHeightMM
+	}
+	return h
+}
+
+func shortestColumn(heights []float64) int {
+	best := 0
+	for i, h := range heights {
+		if h < heights[best] {
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Placement of scaled copies of each document page on a printer sheet
+type imposition struct {
+	Sheet  pageSpec
+	Scale  float64
+	Copies [][2]float64 // top-left corner of each copy on the sheet, in mm
+}
+
+// Lays out n copies of the page on the sheet size, trying both sheet
+// orientations and every rows x cols split of n, and keeps the one that
+// scales the copies least. n <= 1 leaves pages untouched.
+func newImposition(page pageSpec, sheetSize string, n int) (imposition, error) {
+	if n <= 1 {
+		return imposition{Sheet: page, Scale: 1}, nil
+	}
+	if n != 2 && n != 4 {
+		return imposition{}, fmt.Errorf("nup must be 2 or 4")
+	}
+	if sheetSize == "" {
+		sheetSize = page.Size
+	}
+	size, ok := lookupPaperSize(sheetSize)
+	if !ok {
+		return imposition{}, fmt.Errorf("unknown sheet size %q", sheetSize)
+	}
+
+	var best imposition
+	for _, orientation := range []string{"L", "P"} {
+		sheet := newPageSpec(size, orientation)
+		for rows := 1; rows <= n; rows++ {
+			if n%rows != 0 {
+				continue
+			}
+			cols := n / rows
+			slotW, slotH := sheet.Width/float64(cols), sheet.Height/float64(rows)
+			scale := min(1, slotW/page.Width, slotH/page.Height)
+			if scale <= best.Scale {
+				continue
+			}
+
+			w, h := page.Width*scale, page.Height*scale
+			copies := make([][2]float64, 0, n)
+			for r := 0; r < rows; r++ {
+				for c := 0; c < cols; c++ {
+					copies = append(copies, [2]float64{
+						float64(c)*slotW + (slotW-w)/2,
+						float64(r)*slotH + (slotH-h)/2,
+					})
+				}
+			}
+			best = imposition{Sheet: sheet, Scale: scale, Copies: copies}
+		}
+	}
+	return best, nil
+}
+
+// Runs fn once per copy with the page coordinates mapped onto that copy
+func (im imposition) draw(pdf *fpdf.Fpdf, fn func()) {
+	if len(im.Copies) == 0 {
+		fn()
+		return
+	}
+	for _, at := range im.Copies {
+		pdf.TransformBegin()
+		pdf.TransformTranslate(at[0], at[1])
+		pdf.TransformScale(im.Scale*100, im.Scale*100, 0, 0)
+		fn()
+		pdf.TransformEnd()
+	}
+}
+
+func lookupPaperSize(name string) (string, bool) {
+	for size := range paperSizesMM {
+		if strings.EqualFold(size, strings.TrimSpace(name)) {
+			return size, true
+		}
+	}
+	return "", false
+}
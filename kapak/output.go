@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Where the output goes when its intended location cannot be written
+func fallbackPath(name, fallbackDir string) string {
+	return filepath.Join(fallbackDir, filepath.Base(name))
+}
+
+// Writes the output file, retrying under fallbackDir (when set) if that
+// fails; returns the path actually written
+func writeOutput(name string, data []byte, fallbackDir string) (string, error) {
+	err := os.WriteFile(name, data, 0o644)
+	if err == nil || fallbackDir == "" {
+		return name, err
+	}
+	logf("Unable to save %s: %v\n", name, err)
+	alt := fallbackPath(name, fallbackDir)
+	if altErr := os.WriteFile(alt, data, 0o644); altErr != nil {
+		return "", fmt.Errorf("%w; fallback %s: %v", err, alt, altErr)
+	}
+	return alt, nil
+}
+
+// Creates the output file for streaming, falling back like writeOutput.
+// Only creation can fall back: a document streamed halfway is gone.
+func createOutput(name, fallbackDir string) (*os.File, error) {
+	f, err := os.Create(name)
+	if err == nil || fallbackDir == "" {
+		return f, err
+	}
+	logf("Unable to save %s: %v\n", name, err)
+	alt := fallbackPath(name, fallbackDir)
+	f, altErr := os.Create(alt)
+	if altErr != nil {
+		return nil, fmt.Errorf("%w; fallback %s: %v", err, alt, altErr)
+	}
+	return f, nil
+}
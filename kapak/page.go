@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Paper sizes known to fpdf, as portrait width and height in mm
+var paperSizesMM = map[string][2]float64{
+	"A3":     {297, 420},
+	"A4":     {210, 297},
+	"A5":     {148, 210},
+	"Letter": {215.9, 279.4},
+	"Legal":  {215.9, 355.6},
+}
+
+// Page sizes tried by -auto-page; order breaks ties between equally good fits
+var autoPageSizes = []string{"A4", "A3", "A5", "Letter", "Legal"}
+
+type pageSpec struct {
+	Size        string
+	Orientation string
+	Width       float64
+	Height      float64
+}
+
+func newPageSpec(size, orientation string) pageSpec {
+	dims := paperSizesMM[size]
+	w, h := dims[0], dims[1]
+	if orientation == "L" {
+		w, h = h, w
+	}
+	return pageSpec{Size: size, Orientation: orientation, Width: w, Height: h}
+}
+
+func (p pageSpec) String() string {
+	if p.Orientation == "L" {
+		return p.Size + " landscape"
+	}
+	return p.Size + " portrait"
+}
+
+func parseCellSize(value string) (float64, float64, error) {
+	clean := strings.ToLower(strings.TrimSpace(value))
+	parts := strings.Split(clean, "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("cell size must be widthxheight in mm")
+	}
+
+	w, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || w <= 0 {
+		return 0, 0, fmt.Errorf("width value must be positive")
+	}
+
+	h, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || h <= 0 {
+		return 0, 0, fmt.Errorf("height value must be positive")
+	}
+
+	return w, h, nil
+}
+
+// Default -strip thumbnail, cover-shaped once the cell padding is taken off
+const (
+	stripCellWidthMM  = 30.0
+	stripCellHeightMM = 42.0
+)
+
+// Lays out a single row of thumbnails along a landscape page, or a single
+// column down a portrait one, fitting as many cells as the page holds.
+// Zero cell dimensions use the strip defaults.
+func stripGrid(direction string, cellW, cellH float64) (pageSpec, gridLayout, error) {
+	if cellW <= 0 || cellH <= 0 {
+		cellW, cellH = stripCellWidthMM, stripCellHeightMM
+	}
+	var page pageSpec
+	var rows, cols int
+	switch direction {
+	case "row":
+		page = newPageSpec(defaultPageSize, "L")
+		_, cols = gridForCell(page, cellW, cellH)
+		rows = 1
+	case "column":
+		page = newPageSpec(defaultPageSize, "P")
+		rows, _ = gridForCell(page, cellW, cellH)
+		cols = 1
+	default:
+		return page, gridLayout{}, fmt.Errorf("strip must be row or column")
+	}
+	if rows <= 0 || cols <= 0 || cellW > page.Width-2*pageMarginXMM || cellH > page.Height-2*pageMarginYMM {
+		return page, gridLayout{}, fmt.Errorf("cell size %gx%g mm does not fit on %s", cellW, cellH, page)
+	}
+	return page, newGridLayout(page, rows, cols, cellW, cellH), nil
+}
+
+// Returns how many cells of the given size fit inside the page margins
+func gridForCell(page pageSpec, cellW, cellH float64) (int, int) {
+	rows := int((page.Height - 2*pageMarginYMM) / cellH)
+	cols := int((page.Width - 2*pageMarginXMM) / cellW)
+	return rows, cols
+}
+
+// Returns the most square grid with at least count cells, putting the
+// longer side along the longer page edge
+func gridForCount(page pageSpec, count int) (int, int) {
+	count = max(count, 1)
+	long := int(math.Ceil(math.Sqrt(float64(count))))
+	short := (count + long - 1) / long
+	if page.Height > page.Width {
+		return long, short
+	}
+	return short, long
+}
+
+// Picks the candidate page that holds all codes on the fewest pages,
+// preferring the smaller paper when page counts are equal
+func chooseAutoPage(count int, cellW, cellH float64) (pageSpec, int, int, error) {
+	var best pageSpec
+	var bestRows, bestCols, bestPages int
+	var bestArea float64
+
+	for _, size := range autoPageSizes {
+		for _, orientation := range []string{"L", "P"} {
+			page := newPageSpec(size, orientation)
+			rows, cols := gridForCell(page, cellW, cellH)
+			if rows <= 0 || cols <= 0 {
+				continue
+			}
+
+			pages := int(math.Ceil(float64(count) / float64(rows*cols)))
+			area := page.Width * page.Height
+			if bestPages == 0 || pages < bestPages || (pages == bestPages && area < bestArea) {
+				best, bestRows, bestCols, bestPages, bestArea = page, rows, cols, pages, area
+			}
+		}
+	}
+
+	if bestPages == 0 {
+		return pageSpec{}, 0, 0, fmt.Errorf("cell size %gx%g mm does not fit on any page", cellW, cellH)
+	}
+	return best, bestRows, bestCols, nil
+}
+
+// Named -aspect hints as width/height ratios
+var aspectHints = map[string]float64{
+	"portrait":  2.0 / 3.0,
+	"square":    1,
+	"landscape": 3.0 / 2.0,
+}
+
+// Parses an -aspect hint: a name, a width:height pair or a plain ratio.
+// An empty value returns 0, meaning the fit follows the image content.
+func parseAspect(value string) (float64, error) {
+	clean := strings.ToLower(strings.TrimSpace(value))
+	if clean == "" {
+		return 0, nil
+	}
+	if ratio, ok := aspectHints[clean]; ok {
+		return ratio, nil
+	}
+
+	if w, h, found := strings.Cut(clean, ":"); found {
+		wv, errW := strconv.ParseFloat(w, 64)
+		hv, errH := strconv.ParseFloat(h, 64)
+		if errW != nil || errH != nil || wv <= 0 || hv <= 0 {
+			return 0, fmt.Errorf("aspect must be width:height with positive values")
+		}
+		return wv / hv, nil
+	}
+
+	ratio, err := strconv.ParseFloat(clean, 64)
+	if err != nil || ratio <= 0 {
+		return 0, fmt.Errorf("aspect must be portrait, square, landscape or a positive ratio")
+	}
+	return ratio, nil
+}
+
+// Returns the largest box with the given width/height ratio inside w x h
+func fitBox(w, h, ratio float64) (float64, float64) {
+	if w/h > ratio {
+		return h * ratio, h
+	}
+	return w, w / ratio
+}
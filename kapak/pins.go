@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fixed cell of a pinned code; page, row and col are 0-based
+type cellPin struct {
+	Page int
+	Row  int
+	Col  int
+}
+
+func (p cellPin) String() string {
+	return fmt.Sprintf("%d:%d,%d", p.Page+1, p.Row+1, p.Col+1)
+}
+
+// Splits a trailing position such as "@2,3" (row 2, column 3 of the first
+// page) or "@2:1,4" (page 2) off an input line. Positions are 1-based in
+// reading order. Lines without a valid suffix are returned unchanged.
+func cutPin(line string) (string, cellPin, bool) {
+	idx := strings.LastIndex(line, "@")
+	if idx <= 0 {
+		return line, cellPin{}, false
+	}
+	spec := strings.TrimSpace(line[idx+1:])
+	page := 1
+	if p, rest, ok := strings.Cut(spec, ":"); ok {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 {
+			return line, cellPin{}, false
+		}
+		page, spec = n, rest
+	}
+	r, c, ok := strings.Cut(spec, ",")
+	if !ok {
+		return line, cellPin{}, false
+	}
+	row, err1 := strconv.Atoi(strings.TrimSpace(r))
+	col, err2 := strconv.Atoi(strings.TrimSpace(c))
+	if err1 != nil || err2 != nil || row <= 0 || col <= 0 {
+		return line, cellPin{}, false
+	}
+	return strings.TrimSpace(line[:idx]), cellPin{Page: page - 1, Row: row - 1, Col: col - 1}, true
+}
+
+// Reports the first pin that lies outside the grid
+func checkPins(pins map[string]cellPin, g gridLayout) error {
+	for code, pin := range pins {
+		if pin.Row >= g.Rows || pin.Col >= g.Cols {
+			return fmt.Errorf("%s is pinned to row %d, column %d, outside the %dx%d grid", code, pin.Row+1, pin.Col+1, g.Rows, g.Cols)
+		}
+	}
+	return nil
+}
+
+// Puts the first occurrence of each pinned code in its cell and flows the
+// other items in reading order through the cells that remain
+func (g gridLayout) placePins(items []coverItem, pins map[string]cellPin, groupMisses bool) []cellPlacement {
+	perPage := g.cellsPerPage()
+	slotOf := func(p cellPin) int { return p.Page*perPage + p.Row*g.Cols + p.Col }
+
+	pinned := make(map[int]bool)
+	reserved := make(map[int]bool)
+	for i, item := range items {
+		if pin, ok := pins[item.Code]; ok && !reserved[slotOf(pin)] {
+			pinned[i] = true
+			reserved[slotOf(pin)] = true
+		}
+	}
+
+	order, breakAt := placementOrder(items, groupMisses)
+	placements := make([]cellPlacement, len(items))
+	slot := 0
+	for n, i := range order {
+		if n == breakAt {
+			slot = (slot + perPage - 1) / perPage * perPage
+		}
+		if pinned[i] {
+			placements[i] = g.place(slotOf(pins[items[i].Code]))
+			continue
+		}
+		for reserved[slot] {
+			slot++
+		}
+		placements[i] = g.place(slot)
+		slot++
+	}
+	return placements
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Repeatable -source-rate flag collecting NAME=N request rates, where NAME
+// is a source name or a host
+type rateList struct {
+	rates map[string]float64
+}
+
+func (r *rateList) String() string {
+	if r == nil {
+		return ""
+	}
+	var parts []string
+	for name, rate := range r.rates {
+		parts = append(parts, name+"="+strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func (r *rateList) Set(value string) error {
+	name, n, ok := strings.Cut(value, "=")
+	name = strings.ToLower(strings.TrimSpace(name))
+	rate, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+	if !ok || name == "" || err != nil || rate < 0 {
+		return fmt.Errorf("rate must be in NAME=N form with N requests per second, e.g. dr=2")
+	}
+	if r.rates == nil {
+		r.rates = make(map[string]float64)
+	}
+	r.rates[name] = rate
+	return nil
+}
+
+// Spaces out requests to each host so that none receives more than its
+// configured number per second; hosts without a rate are not held back.
+// Safe for concurrent use.
+type hostLimiter struct {
+	mu sync.Mutex
+	// Keyed by host name, ports ignored
+	interval map[string]time.Duration
+	next     map[string]time.Time
+}
+
+// Builds the limits from the sources' declared rates, overridden by the
+// -source-rate entries; a source name applies to every host it uses
+func newHostLimiter(declared []imageSource, overrides map[string]float64) *hostLimiter {
+	rates := make(map[string]float64)
+	for _, src := range declared {
+		for _, host := range src.hosts() {
+			if src.Rate > 0 {
+				rates[host] = src.Rate
+			}
+		}
+	}
+	for name, rate := range overrides {
+		hosts := []string{name}
+		if src, ok := imageSources[name]; ok {
+			hosts = src.hosts()
+		}
+		for _, host := range hosts {
+			rates[host] = rate
+		}
+	}
+
+	l := &hostLimiter{interval: make(map[string]time.Duration), next: make(map[string]time.Time)}
+	for host, rate := range rates {
+		if rate > 0 {
+			l.interval[host] = time.Duration(float64(time.Second) / rate)
+		}
+	}
+	return l
+}
+
+// Blocks until a request to the URL's host is allowed
+func (l *hostLimiter) wait(rawURL string) {
+	if l == nil {
+		return
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	host := strings.ToLower(u.Hostname())
+	interval, ok := l.interval[host]
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next[host]
+	if start.Before(now) {
+		start = now
+	}
+	l.next[host] = start.Add(interval)
+	l.mu.Unlock()
+
+	if delay := time.Until(start); delay > 0 {
+		debugf("Pacing %s: waiting %s\n", host, delay.Round(time.Millisecond))
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A fixed sheet layout, with every dimension in mm as printed on the sheet
+type layoutPreset struct {
+	Description string
+	Page        pageSpec
+	Rows        int
+	Cols        int
+	CellWidth   float64
+	CellHeight  float64
+	MarginTop   float64
+	MarginLeft  float64
+	PitchX      float64
+	PitchY      float64
+}
+
+// Label sheets from the Avery A4 range; sheets of other brands (e.g. Tanex)
+// with the same dimensions can use the same entries
+var layoutPresets = map[string]layoutPreset{
+	"avery-l7159": {
+		Description: "Avery L7159, 24 labels 63.5x33.9 mm",
+		Page:        newPageSpec("A4", "P"),
+		Rows:        8, Cols: 3,
+		CellWidth: 63.5, CellHeight: 33.9,
+		MarginTop: 12.9, MarginLeft: 6.45,
+		PitchX: 66.0, PitchY: 33.9,
+	},
+	"avery-l7160": {
+		Description: "Avery L7160, 21 labels 63.5x38.1 mm",
+		Page:        newPageSpec("A4", "P"),
+		Rows:        7, Cols: 3,
+		CellWidth: 63.5, CellHeight: 38.1,
+		MarginTop: 15.15, MarginLeft: 7.21,
+		PitchX: 66.04, PitchY: 38.1,
+	},
+	"avery-l7163": {
+		Description: "Avery L7163, 14 labels 99.1x38.1 mm",
+		Page:        newPageSpec("A4", "P"),
+		Rows:        7, Cols: 2,
+		CellWidth: 99.1, CellHeight: 38.1,
+		MarginTop: 15.15, MarginLeft: 4.65,
+		PitchX: 101.6, PitchY: 38.1,
+	},
+	"avery-l7165": {
+		Description: "Avery L7165, 8 labels 99.1x67.7 mm",
+		Page:        newPageSpec("A4", "P"),
+		Rows:        4, Cols: 2,
+		CellWidth: 99.1, CellHeight: 67.7,
+		MarginTop: 13.1, MarginLeft: 4.65,
+		PitchX: 101.6, PitchY: 67.7,
+	},
+	"avery-l7651": {
+		Description: "Avery L7651, 65 labels 38.1x21.2 mm",
+		Page:        newPageSpec("A4", "P"),
+		Rows:        13, Cols: 5,
+		CellWidth: 38.1, CellHeight: 21.2,
+		MarginTop: 10.7, MarginLeft: 4.75,
+		PitchX: 40.6, PitchY: 21.2,
+	},
+}
+
+func lookupPreset(name string) (layoutPreset, error) {
+	preset, ok := layoutPresets[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return layoutPreset{}, fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(presetNames(), ", "))
+	}
+	return preset, nil
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(layoutPresets))
+	for name := range layoutPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p layoutPreset) grid() gridLayout {
+	return gridLayout{
+		Rows:       p.Rows,
+		Cols:       p.Cols,
+		CellWidth:  p.CellWidth,
+		CellHeight: p.CellHeight,
+		OriginX:    p.MarginLeft,
+		OriginY:    p.MarginTop,
+		GutterX:    p.PitchX - p.CellWidth,
+		GutterY:    p.PitchY - p.CellHeight,
+	}
+}
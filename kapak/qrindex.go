@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/boombuler/barcode/qr"
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	qrCellWidthMM  = 30.0
+	qrCellHeightMM = 36.0
+	qrPaddingMM    = 3.0
+	qrLabelHeight  = 4.0
+	qrLabelSize    = 6.0
+)
+
+// Draws pages of QR codes after the grid, one per code in input order,
+// each encoding the code's product page link and labeled with the code
+func drawQRIndex(pdf *fpdf.Fpdf, page pageSpec, items []coverItem, addPage func()) {
+	rows, cols := gridForCell(page, qrCellWidthMM, qrCellHeightMM)
+	index := newGridLayout(page, rows, cols, qrCellWidthMM, qrCellHeightMM)
+	side := min(qrCellWidthMM, qrCellHeightMM-qrLabelHeight) - 2*qrPaddingMM
+
+	first := pdf.PageCount()
+	n := 0
+	for _, item := range items {
+		if item.Status == statusBlank || item.Link == "" {
+			continue
+		}
+		cell := index.place(n)
+		n++
+		for pdf.PageCount() < first+cell.Page+1 {
+			addPage()
+		}
+		x := cell.X + (cell.Width-side)/2
+		y := cell.Y + qrPaddingMM
+		if err := drawQR(pdf, item.Link, x, y, side); err != nil {
+			debugf("Unable to encode QR for %s: %v\n", item.Code, err)
+		}
+		pdf.LinkString(x, y, side, side, item.Link)
+
+		pdf.SetFont("Arial", "", qrLabelSize)
+		pdf.SetXY(cell.X, y+side)
+		pdf.CellFormat(cell.Width, qrLabelHeight, toASCII(item.Code), "", 0, "C", false, 0, "")
+	}
+}
+
+// Draws the QR code of text as filled squares in a size x size mm box
+func drawQR(pdf *fpdf.Fpdf, text string, x, y, size float64) error {
+	code, err := qr.Encode(text, qr.M, qr.Auto)
+	if err != nil {
+		return err
+	}
+	bounds := code.Bounds()
+	module := size / float64(bounds.Dx())
+	pdf.SetFillColor(0, 0, 0)
+	for row := bounds.Min.Y; row < bounds.Max.Y; row++ {
+		for col := bounds.Min.X; col < bounds.Max.X; col++ {
+			if r, _, _, _ := code.At(col, row).RGBA(); r < 0x8000 {
+				pdf.Rect(x+float64(col-bounds.Min.X)*module, y+float64(row-bounds.Min.Y)*module, module, module, "F")
+			}
+		}
+	}
+	pdf.SetFillColor(255, 255, 255)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Largest code list accepted from a URL
+const maxRemoteListBytes = 8 << 20
+
+var (
+	htmlScriptPattern = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// Reports whether the input argument names a remote list
+func isRemoteList(arg string) bool {
+	return strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")
+}
+
+// Downloads a code list. Anything but plain text is refused, since a
+// shared link often lands on a web page rather than the raw file; with
+// scanHTML an HTML page is reduced to its visible text and scanned instead.
+func fetchRemoteList(client *http.Client, rawURL string, scanHTML bool) (string, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", httpUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("status: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteListBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxRemoteListBytes {
+		return "", fmt.Errorf("list too large: over %d bytes", maxRemoteListBytes)
+	}
+
+	declared := resp.Header.Get("Content-Type")
+	kind := listContentKind(declared, data)
+	switch {
+	case kind == "text/html" && scanHTML:
+		debugf("Scanning the text of the HTML page at %s\n", rawURL)
+		return htmlText(string(data)), nil
+	case kind == "text/html":
+		return "", fmt.Errorf("got an HTML page instead of a text list (content type %q); use -scan-html to scan it for codes", declared)
+	case !strings.HasPrefix(kind, "text/"):
+		return "", fmt.Errorf("not a text list (content type %q)", declared)
+	}
+	return string(data), nil
+}
+
+// Media type of a downloaded list: the declared one, unless it is missing,
+// generic or contradicted by an HTML body
+func listContentKind(declared string, data []byte) string {
+	kind, _, err := mime.ParseMediaType(declared)
+	if err != nil || kind == "application/octet-stream" {
+		kind, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if kind == "application/xhtml+xml" || looksLikeHTML(data) {
+		kind = "text/html"
+	}
+	return kind
+}
+
+// Strips scripts, styles and tags, one line per element
+func htmlText(page string) string {
+	page = htmlScriptPattern.ReplaceAllString(page, "")
+	page = htmlTagPattern.ReplaceAllString(page, "\n")
+	return html.UnescapeString(page)
+}
+
+// Output file for a remote list, named after the last path segment
+func remoteOutputName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return defaultOutputName
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return defaultOutputName
+	}
+	return strings.TrimSuffix(name, path.Ext(name)) + ".pdf"
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	placeholderName     = "placeholder"
+	backgroundName      = "background"
+	captionHeightMM     = 5.0
+	captionFontSize     = 8.0
+	overlayAlpha        = 0.75
+	defaultMinFontSize  = 5.0
+	defaultShadowOffset = 1.2
+	defaultShadowBlur   = 1.0
+	defaultMatColor     = "#F3EFE6"
+	shadowLayers        = 4
+	shadowAlpha         = 0.08
+	headingFontSize     = 14.0
+	codeLabelFontSize   = 5.0
+	codeLabelGray       = 140
+	zebraGray           = 242
+	// Print resolution below which a cover counts as low resolution
+	lowPrintDPI = 150
+)
+
+// Document information dictionary entries
+type pdfMetadata struct {
+	Title    string
+	Author   string
+	Subject  string
+	Keywords string
+	Created  time.Time
+}
+
+type renderOptions struct {
+	Page        pageSpec
+	Grid        gridLayout
+	Aspect      float64
+	CropMarks   bool
+	Ruler       bool
+	Placeholder *localImage
+	Metadata    pdfMetadata
+	// Title printed in the top margin of the first page
+	Heading string
+	// Move failed codes onto their own pages after all covers
+	GroupMisses bool
+	// Draw the border of the empty cells left by blank input lines
+	BlankBorders bool
+	// Release each item's image data once it is embedded; the items
+	// cannot be rendered again afterwards
+	LowMemory bool
+	// Covers that occupy more than one grid cell, by code
+	Spans map[string]cellSpan
+	// Codes fixed to a cell, with the other items flowing around them
+	Pins map[string]cellPin
+	// Stack covers of varying aspect in columns instead of grid rows
+	Masonry bool
+	// Covers whose shape differs from the cell's by more than this factor
+	// are cropped to fill it; 0 always fits the whole cover
+	MaxAspect float64
+
+	// Start with pages of small linked thumbnails of every cover
+	ThumbnailIndex bool
+	// End with pages of QR codes linking to each code's product page
+	QRIndex bool
+	// Print the code in the top corner of found covers too
+	ShowCodes bool
+	// Attach each item's source text to its cell as a note viewers show on hover
+	Annotations bool
+
+	// Copies of each page per printed sheet; the zero value prints pages as they are
+	Imposition imposition
+
+	// Page fill and full-page image drawn under the grid; nil keeps white pages
+	BackgroundColor *[3]int
+	BackgroundImage *localImage
+
+	StatusBorders bool
+	MinFontSize   float64
+	// Placement of found covers' captions; failed cells keep the code below
+	CaptionPosition captionPosition
+	// Filled frame drawn around each cover inside its cell
+	MatWidth float64
+	MatColor [3]int
+	// Enlarge the cells of a partial last page to fill it
+	CompactEmpty bool
+	// Center a partial final row instead of leaving it ragged
+	JustifyLast bool
+	// Alternate tint behind rows or cells
+	Zebra zebraMode
+
+	Shadow       bool
+	ShadowOffset float64
+	ShadowBlur   float64
+}
+
+// Renders the items onto a new PDF and returns it with a description of the layout
+func renderPDF(items []coverItem, opts renderOptions) (*fpdf.Fpdf, *manifest) {
+	page, grid := opts.Page, opts.Grid
+
+	sheet := opts.Imposition
+	if sheet.Scale == 0 {
+		sheet = imposition{Sheet: page, Scale: 1}
+	}
+
+	pdf := fpdf.New(sheet.Sheet.Orientation, "mm", sheet.Sheet.Size, "")
+	pdf.SetFont("Arial", "", 12)
+	// Cells are placed explicitly; text near the bottom edge must not spill onto a new page
+	pdf.SetAutoPageBreak(false, 0)
+	setMetadata(pdf, opts.Metadata)
+
+	if opts.Ruler {
+		// Drawn on the sheet at true scale, since it checks the printer
+		pdf.SetFooterFunc(func() { drawRuler(pdf, sheet.Sheet) })
+	}
+	if opts.BackgroundColor != nil || opts.BackgroundImage != nil {
+		if bg := opts.BackgroundImage; bg != nil {
+			opt := fpdf.ImageOptions{ImageType: bg.Format, ReadDpi: true}
+			pdf.RegisterImageOptionsReader(backgroundName, opt, bytes.NewReader(bg.Data))
+			if failImageRegistration(pdf, "page background") {
+				return pdf, nil
+			}
+		}
+		pdf.SetHeaderFunc(func() {
+			sheet.draw(pdf, func() { drawBackground(pdf, page, opts.BackgroundColor, opts.BackgroundImage) })
+		})
+	}
+
+	placeholder := opts.Placeholder
+	if placeholder != nil {
+		opt := fpdf.ImageOptions{ImageType: placeholder.Format, ReadDpi: true}
+		pdf.RegisterImageOptionsReader(placeholderName, opt, bytes.NewReader(placeholder.Data))
+		if failImageRegistration(pdf, "placeholder") {
+			return pdf, nil
+		}
+	}
+
+	caption := captionStyle{Align: "C", MinFontSize: opts.MinFontSize}
+	if grid.RTL {
+		caption.Align = "R"
+	}
+
+	layout := &manifest{
+		Page: manifestPage{Size: page.Size, Orientation: page.Orientation, WidthMM: page.Width, HeightMM: page.Height},
+		Grid: manifestGrid{Rows: grid.Rows, Cols: grid.Cols, CellWidthMM: grid.CellWidth, CellHeightMM: grid.CellHeight},
+	}
+
+	cells := planCells(items, opts)
+
+	// Printed size in mm of the cover last drawn by drawCell
+	var shownW float64
+
+	// Draws one cell: its border, crop marks and cover or failure text
+	drawCell := func(i int, item coverItem, cell cellPlacement) {
+		x, y := cell.X, cell.Y
+		cellWidth, cellHeight := cell.Width, cell.Height
+
+		if opts.Zebra.tinted(cell) {
+			pdf.SetFillColor(zebraGray, zebraGray, zebraGray)
+			pdf.Rect(x, y, cellWidth, cellHeight, "F")
+			pdf.SetFillColor(255, 255, 255)
+		}
+
+		// Content box the covers are fitted into, shaped by the aspect hint
+		boxW, boxH := cellWidth-contentPaddingMM, cellHeight-contentPaddingMM
+		if opts.Aspect > 0 && !opts.Masonry {
+			boxW, boxH = fitBox(boxW, boxH, opts.Aspect)
+		}
+		borderW := boxW + contentPaddingMM - (2 * cellBorderInsetMM)
+		borderH := boxH + contentPaddingMM - (2 * cellBorderInsetMM)
+
+		pdf.SetLineWidth(cellBorderWidth)
+		if opts.StatusBorders {
+			c := statusBorderColors[item.Status]
+			pdf.SetDrawColor(c[0], c[1], c[2])
+		} else {
+			pdf.SetDrawColor(cellBorderGray, cellBorderGray, cellBorderGray)
+		}
+		pdf.Rect(x+(cellWidth-borderW)/2, y+(cellHeight-borderH)/2, borderW, borderH, "D")
+		pdf.SetDrawColor(0, 0, 0)
+
+		if opts.CropMarks {
+			spaceX, spaceY := (cellWidth-borderW)/2, (cellHeight-borderH)/2
+			drawCropMarks(pdf, x+spaceX, y+spaceY, borderW, borderH, spaceX, spaceY)
+		}
+		if item.Status == statusBlank {
+			return
+		}
+
+		boxX := x + (cellWidth-boxW)/2
+		boxTop := y + (cellHeight-boxH)/2
+
+		switch {
+		case item.Status == statusOK:
+			// A caption takes the bottom (or top) line of the content box,
+			// unless it is laid over the cover itself
+			imageBoxH, imageTop := boxH, boxTop
+			captionY := boxTop + boxH - captionHeightMM
+			if item.Caption != "" && opts.CaptionPosition != captionOverlay {
+				imageBoxH -= captionHeightMM
+				if opts.CaptionPosition == captionAbove {
+					imageTop += captionHeightMM
+					captionY = boxTop
+				}
+			}
+			// A mat frames the cover inside the content box; it never takes
+			// more than half of the box
+			mat := max(0, min(opts.MatWidth, boxW/4, imageBoxH/4))
+			imageLeft, imageW := boxX+mat, boxW-2*mat
+			imageTop, imageBoxH = imageTop+mat, imageBoxH-2*mat
+
+			crop := isExtremeAspect(item.Config, imageW, imageBoxH, opts.MaxAspect)
+			displayW, displayH := fitImage(item.Config, imageW, imageBoxH)
+			if crop {
+				displayW, displayH = coverImage(item.Config, imageW, imageBoxH)
+			}
+
+			centerX := x + (cellWidth-displayW)/2
+			centerY := imageTop + (imageBoxH-displayH)/2
+			shownW = displayW
+
+			// Visible extent of the cover, which the mat and shadow surround
+			frameX, frameY, frameW, frameH := centerX, centerY, displayW, displayH
+			if crop {
+				frameX, frameY, frameW, frameH = imageLeft, imageTop, imageW, imageBoxH
+			}
+			if opts.Shadow {
+				drawShadow(pdf, frameX-mat, frameY-mat, frameW+2*mat, frameH+2*mat, opts.ShadowOffset, opts.ShadowBlur)
+			}
+			if mat > 0 {
+				c := opts.MatColor
+				pdf.SetFillColor(c[0], c[1], c[2])
+				pdf.Rect(frameX-mat, frameY-mat, frameW+2*mat, frameH+2*mat, "F")
+				pdf.SetFillColor(255, 255, 255)
+			}
+
+			imageName, opt, _ := registerItemImage(pdf, item)
+			if crop {
+				pdf.ClipRect(imageLeft, imageTop, imageW, imageBoxH, false)
+			}
+			pdf.ImageOptions(imageName, centerX, centerY, displayW, displayH, false, opt, 0, "")
+			if crop {
+				pdf.ClipEnd()
+			}
+
+			switch {
+			case item.Caption == "":
+			case opts.CaptionPosition == captionOverlay:
+				bandX, bandY, bandW := frameX, frameY+frameH-captionHeightMM, frameW
+				pdf.SetAlpha(overlayAlpha, "Normal")
+				pdf.SetFillColor(255, 255, 255)
+				pdf.Rect(bandX, bandY, bandW, captionHeightMM, "F")
+				pdf.SetAlpha(1, "Normal")
+				drawCaption(pdf, bandX, bandY, bandW, item.Caption, caption)
+			default:
+				drawCaption(pdf, boxX, captionY, boxW, item.Caption, caption)
+			}
+			if opts.ShowCodes {
+				borderTop := y + (cellHeight-borderH)/2
+				drawCodeLabel(pdf, x+(cellWidth-borderW)/2, borderTop, borderW, boxTop-borderTop, item.Code, grid.RTL)
+			}
+
+		case item.Status == statusInvalidFormat:
+			drawAsciiText(pdf, x, y, cellWidth, cellHeight, "INVALID FORMAT")
+
+		case placeholder != nil:
+			// Placeholder fills the content box above a single caption line with the ID
+			displayW, displayH := fitImage(placeholder.Config, boxW, boxH-captionHeightMM)
+			centerX := x + (cellWidth-displayW)/2
+			centerY := boxTop + (boxH-captionHeightMM-displayH)/2
+
+			opt := fpdf.ImageOptions{ImageType: placeholder.Format, ReadDpi: true}
+			pdf.ImageOptions(placeholderName, centerX, centerY, displayW, displayH, false, opt, 0, "")
+
+			drawCaption(pdf, boxX, boxTop+boxH-captionHeightMM, boxW, item.codeLine(), caption)
+
+		default:
+			drawAsciiText(pdf, x, y, cellWidth, cellHeight, "NOT FOUND")
+
+			drawCaption(pdf, boxX, y+cellHeight-contentPaddingMM, boxW, item.codeLine(), caption)
+		}
+	}
+
+	addPage := func() {
+		pdf.AddPage()
+		if pdf.PageCount() == 1 && opts.Heading != "" {
+			sheet.draw(pdf, func() { drawHeading(pdf, page, opts.Heading) })
+		}
+	}
+
+	// Index pages come first, so grid pages are numbered after them
+	offset := 0
+	if opts.ThumbnailIndex {
+		offset = thumbnailPages(page, items)
+		drawThumbnailIndex(pdf, page, items, cells, offset, addPage)
+	}
+
+	for i, item := range items {
+		cell := cells[i]
+		cell.Page += offset
+		for pdf.PageCount() <= cell.Page {
+			addPage()
+		}
+		// Grouped misses and pins can place an item on a later page than
+		// the ones after it, so each goes back to its own page
+		pdf.SetPage(cell.Page + 1)
+		if item.Status == statusBlank {
+			if opts.BlankBorders {
+				sheet.draw(pdf, func() { drawCell(i, item, cell) })
+			}
+			layout.Items = append(layout.Items, manifestItem{Page: cell.Page + 1, Row: cell.Row + 1, Col: cell.Col + 1, Status: item.Status})
+			continue
+		}
+		if item.Status == statusOK {
+			// An image fpdf cannot embed shows as invalid instead of
+			// failing the whole document at output
+			if _, _, ok := registerItemImage(pdf, item); !ok {
+				logf("Warning: unable to embed the cover of %s; marking it invalid.\n", item.Code)
+				item.Status = statusInvalidFormat
+				items[i].Status = statusInvalidFormat
+			}
+		}
+		sheet.draw(pdf, func() { drawCell(i, item, cell) })
+		if opts.LowMemory {
+			// fpdf keeps its own copy of every registered image
+			items[i].Data = nil
+		}
+
+		if opts.Annotations && item.Source != "" {
+			// fpdf has no plain text annotations; a file attachment annotation
+			// carries the text as its hover description and draws nothing
+			note := &fpdf.Attachment{Content: []byte(item.Source + "\n"), Filename: item.Code + ".txt", Description: item.Source}
+			pdf.AddAttachmentAnnotation(note, cell.X, cell.Y, cell.Width, cell.Height)
+		}
+
+		entry := manifestItem{
+			Code:        item.Code,
+			Page:        cell.Page + 1,
+			Row:         cell.Row + 1,
+			Col:         cell.Col + 1,
+			Status:      item.Status,
+			URL:         item.URL,
+			Caption:     item.Caption,
+			Hash:        item.Hash,
+			DuplicateOf: item.DuplicateOf,
+		}
+		if cell.RowSpan > 1 || cell.ColSpan > 1 {
+			entry.RowSpan, entry.ColSpan = cell.RowSpan, cell.ColSpan
+		}
+		if item.Status == statusOK && shownW > 0 {
+			entry.WidthPx, entry.HeightPx = item.Config.Width, item.Config.Height
+			// Measured on the printed sheet, after any -nup scaling
+			entry.PrintDPI = math.Round(float64(item.Config.Width) / (shownW * sheet.Scale / 25.4))
+			entry.LowDPI = entry.PrintDPI < lowPrintDPI
+		}
+		layout.Items = append(layout.Items, entry)
+	}
+
+	// fpdf closes and counts the document from the current page
+	pdf.SetPage(pdf.PageCount())
+
+	if opts.QRIndex {
+		drawQRIndex(pdf, page, items, addPage)
+	}
+
+	return pdf, layout
+}
+
+// Reports whether registering one of the fixed images failed. The error
+// is left on the document, reworded to name the image, so that output
+// fails with it instead of every cover being marked invalid.
+func failImageRegistration(pdf *fpdf.Fpdf, what string) bool {
+	err := pdf.Error()
+	if err == nil {
+		return false
+	}
+	pdf.ClearError()
+	pdf.SetErrorf("unable to embed the %s image: %v", what, err)
+	return true
+}
+
+// Places every item according to the layout mode in the options
+func planCells(items []coverItem, opts renderOptions) []cellPlacement {
+	grid := opts.Grid
+	if opts.Masonry {
+		return grid.placeMasonry(items, opts.GroupMisses)
+	}
+	if len(opts.Spans) > 0 {
+		return grid.placeSpans(items, opts.Spans, opts.GroupMisses)
+	}
+	if len(opts.Pins) > 0 {
+		return grid.placePins(items, opts.Pins, opts.GroupMisses)
+	}
+
+	slots := make([]int, len(items))
+	for i := range items {
+		slots[i] = i
+	}
+	if opts.GroupMisses {
+		slots = groupMissSlots(items, grid.cellsPerPage())
+	}
+	cells := make([]cellPlacement, 0, len(items))
+	for _, slot := range slots {
+		cells = append(cells, grid.place(slot))
+	}
+	if opts.CompactEmpty {
+		cells = grid.compactLastPage(cells)
+	} else if opts.JustifyLast {
+		cells = grid.justifyLastRow(cells)
+	}
+	return cells
+}
+
+// Fills the page with the color and stretches the image over the whole
+// page; runs as each page opens so the grid draws on top
+func drawBackground(pdf *fpdf.Fpdf, page pageSpec, color *[3]int, img *localImage) {
+	if color != nil {
+		pdf.SetFillColor(color[0], color[1], color[2])
+		pdf.Rect(0, 0, page.Width, page.Height, "F")
+		pdf.SetFillColor(255, 255, 255)
+	}
+	if img != nil {
+		opt := fpdf.ImageOptions{ImageType: img.Format, ReadDpi: true}
+		pdf.ImageOptions(backgroundName, 0, 0, page.Width, page.Height, false, opt, 0, "")
+	}
+}
+
+// Parses a #RRGGBB (or RRGGBB) color
+func parseHexColor(value string) ([3]int, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(value), "#")
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return [3]int{}, fmt.Errorf("color must be in #RRGGBB form")
+	}
+	return [3]int{int(n >> 16 & 0xFF), int(n >> 8 & 0xFF), int(n & 0xFF)}, nil
+}
+
+// Cell border colors used by -status-borders
+var statusBorderColors = map[string][3]int{
+	statusOK:            {40, 160, 60},
+	statusNotFound:      {210, 40, 40},
+	statusInvalidFormat: {230, 140, 20},
+	statusBlank:         {cellBorderGray, cellBorderGray, cellBorderGray},
+}
+
+// Where a found cover's caption goes relative to the image
+type captionPosition int
+
+const (
+	captionBelow captionPosition = iota
+	captionAbove
+	// On a translucent band across the bottom of the image
+	captionOverlay
+)
+
+func parseCaptionPosition(value string) (captionPosition, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "below":
+		return captionBelow, nil
+	case "above":
+		return captionAbove, nil
+	case "overlay":
+		return captionOverlay, nil
+	}
+	return captionBelow, fmt.Errorf("caption position must be below, above or overlay")
+}
+
+// Which cells -zebra tints
+type zebraMode int
+
+const (
+	zebraOff zebraMode = iota
+	// Every other row
+	zebraRows
+	// Checkerboard of cells
+	zebraCells
+)
+
+func parseZebra(value string) (zebraMode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "off":
+		return zebraOff, nil
+	case "rows", "row":
+		return zebraRows, nil
+	case "cells", "cell":
+		return zebraCells, nil
+	}
+	return zebraOff, fmt.Errorf("zebra must be rows or cells")
+}
+
+// Reports whether the cell gets the tint; the first row (and cell) stays plain
+func (z zebraMode) tinted(cell cellPlacement) bool {
+	switch z {
+	case zebraRows:
+		return cell.Row%2 == 1
+	case zebraCells:
+		return (cell.Row+cell.Col)%2 == 1
+	}
+	return false
+}
+
+type captionStyle struct {
+	Align       string
+	MinFontSize float64
+}
+
+// Draws a single ASCII-safe caption line. Text too wide for the line is
+// shrunk down to the minimum font size, then truncated with an ellipsis.
+func drawCaption(pdf *fpdf.Fpdf, x, y, w float64, text string, style captionStyle) {
+	text = toASCII(text)
+	avail := w - 2*pdf.GetCellMargin()
+
+	size := captionFontSize
+	pdf.SetFont("Arial", "", size)
+	if width := pdf.GetStringWidth(text); width > avail && avail > 0 {
+		size = max(captionFontSize*avail/width, min(style.MinFontSize, captionFontSize))
+		pdf.SetFontSize(size)
+		if pdf.GetStringWidth(text) > avail {
+			text = truncateToWidth(pdf, text, avail)
+		}
+	}
+
+	pdf.SetXY(x, y)
+	pdf.CellFormat(w, captionHeightMM, text, "", 0, style.Align, false, 0, "")
+}
+
+// Drops trailing characters until the text and an ellipsis fit the width
+func truncateToWidth(pdf *fpdf.Fpdf, text string, width float64) string {
+	const ellipsis = "..."
+	for len(text) > 0 {
+		text = text[:len(text)-1]
+		if pdf.GetStringWidth(text+ellipsis) <= width {
+			return text + ellipsis
+		}
+	}
+	return ""
+}
+
+// Centers the title in the top margin
+func drawHeading(pdf *fpdf.Fpdf, page pageSpec, text string) {
+	pdf.SetFont("Arial", "B", headingFontSize)
+	pdf.SetXY(pageMarginXMM, 0)
+	pdf.CellFormat(page.Width-2*pageMarginXMM, pageMarginYMM, toASCII(text), "", 0, "CM", false, 0, "")
+}
+
+// Writes the code small and light in the band between the cell border and
+// the cover, in the corner where reading starts
+func drawCodeLabel(pdf *fpdf.Fpdf, x, y, w, h float64, code string, rtl bool) {
+	align := "L"
+	if rtl {
+		align = "R"
+	}
+	pdf.SetFont("Arial", "", codeLabelFontSize)
+	pdf.SetTextColor(codeLabelGray, codeLabelGray, codeLabelGray)
+	pdf.SetXY(x, y)
+	pdf.CellFormat(w, h, toASCII(code), "", 0, align+"M", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+}
+
+// Approximates a soft drop shadow with translucent rectangles that grow by
+// blur/shadowLayers each; overlapping layers darken toward the middle
+func drawShadow(pdf *fpdf.Fpdf, x, y, w, h, offset, blur float64) {
+	pdf.SetFillColor(0, 0, 0)
+	pdf.SetAlpha(shadowAlpha, "Normal")
+	for i := shadowLayers; i >= 0; i-- {
+		grow := blur * float64(i) / shadowLayers
+		pdf.Rect(x+offset-grow, y+offset-grow, w+2*grow, h+2*grow, "F")
+	}
+	pdf.SetAlpha(1, "Normal")
+}
+
+func setMetadata(pdf *fpdf.Fpdf, meta pdfMetadata) {
+	pdf.SetCreator("kapak", true)
+	if meta.Title != "" {
+		pdf.SetTitle(meta.Title, true)
+	}
+	if meta.Author != "" {
+		pdf.SetAuthor(meta.Author, true)
+	}
+	if meta.Subject != "" {
+		pdf.SetSubject(meta.Subject, true)
+	}
+	if meta.Keywords != "" {
+		pdf.SetKeywords(meta.Keywords, true)
+	}
+	if !meta.Created.IsZero() {
+		pdf.SetCreationDate(meta.Created)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/go-pdf/fpdf"
+)
+
+func TestRenderPDFEndToEnd(t *testing.T) {
+	// Every code gets a cover of its own width, so none are shared
+	const maxCodes = 30
+	covers := make(map[string][]byte)
+	for n := 1; n <= maxCodes; n++ {
+		covers["/"+strconv.Itoa(n)+".jpg"] = testJPEG(t, 40+n, 60)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		img, ok := covers[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(img)
+	}))
+	defer srv.Close()
+	fetch := testFetcher(srv, "/%s.jpg")
+
+	grid := newGridLayout(testPage, 3, 4, 0, 0)
+	for _, n := range []int{1, 12, 13, maxCodes} {
+		ids := make([]string, n)
+		for i := range ids {
+			ids[i] = strconv.Itoa(i + 1)
+		}
+		items := collectItems(ids, "Downloading ID", func(id string) coverItem {
+			return fetchItem(fetch, id, fetchOptions{})
+		})
+
+		pdf, layout := renderPDF(items, renderOptions{Page: testPage, Grid: grid})
+		if want := (n + grid.cellsPerPage() - 1) / grid.cellsPerPage(); pdf.PageCount() != want {
+			t.Errorf("%d codes: %d pages, want %d", n, pdf.PageCount(), want)
+		}
+		for _, item := range items {
+			if item.Status != statusOK {
+				t.Errorf("%d codes: code %s is %s", n, item.Code, item.Status)
+			} else if pdf.GetImageInfo("img_"+contentHash(item.Data)) == nil {
+				t.Errorf("%d codes: cover of %s not registered", n, item.Code)
+			}
+		}
+		if len(layout.Items) != n {
+			t.Errorf("%d codes: manifest lists %d items", n, len(layout.Items))
+		}
+
+		var out bytes.Buffer
+		if err := pdf.Output(&out); err != nil {
+			t.Fatalf("%d codes: output: %v", n, err)
+		}
+		if got := bytes.Count(out.Bytes(), []byte("/Subtype /Image")); got != n {
+			t.Errorf("%d codes: %d images embedded, want %d", n, got, n)
+		}
+	}
+}
+
+// Found cover with the given image
+func testItem(t *testing.T, code string, data []byte) coverItem {
+	t.Helper()
+	item := prepareItem(coverItem{Code: code}, data, "JPG", fetchOptions{})
+	if item.Status != statusOK {
+		t.Fatalf("code %s: test image is %s", code, item.Status)
+	}
+	return item
+}
+
+// Counts the images drawn on each page of the rendered document
+func imagesPerPage(t *testing.T, pdf *fpdf.Fpdf) []int {
+	t.Helper()
+	pdf.SetCompression(false)
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		t.Fatal(err)
+	}
+	// Each page object is followed by its content stream
+	pages := bytes.Split(out.Bytes(), []byte("<</Type /Page\n"))[1:]
+	counts := make([]int, len(pages))
+	for i, p := range pages {
+		content, _, _ := bytes.Cut(p, []byte("endstream"))
+		counts[i] = len(imageDrawPattern.FindAll(content, -1))
+	}
+	return counts
+}
+
+var imageDrawPattern = regexp.MustCompile(`/I[0-9a-f]+ Do`)
+
+// Counts the covers the manifest places on each page
+func manifestPerPage(layout *manifest, pages int) []int {
+	counts := make([]int, pages)
+	for _, item := range layout.Items {
+		if item.Status == statusOK {
+			counts[item.Page-1]++
+		}
+	}
+	return counts
+}
+
+func TestRenderPDFGroupMissesDrawsOnPlannedPages(t *testing.T) {
+	// A leading miss moves to the page after the 13 covers
+	items := []coverItem{{Code: "0", Status: statusNotFound}}
+	for i := 1; i <= 13; i++ {
+		items = append(items, testItem(t, strconv.Itoa(i), testJPEG(t, 40+i, 60)))
+	}
+	pdf, layout := renderPDF(items, renderOptions{Page: testPage, Grid: newGridLayout(testPage, 3, 4, 0, 0), GroupMisses: true})
+
+	want := []int{12, 1, 0}
+	if got := imagesPerPage(t, pdf); !slices.Equal(got, want) {
+		t.Errorf("images per page %v, want %v", got, want)
+	}
+	if got := manifestPerPage(layout, len(want)); !slices.Equal(got, want) {
+		t.Errorf("manifest covers per page %v, want %v", got, want)
+	}
+}
+
+func TestRenderPDFPinOnLaterPage(t *testing.T) {
+	// The first code is pinned to page 2; the two after it flow onto page 1
+	items := []coverItem{
+		testItem(t, "1", testJPEG(t, 41, 60)),
+		testItem(t, "2", testJPEG(t, 42, 60)),
+		testItem(t, "3", testJPEG(t, 43, 60)),
+	}
+	pins := map[string]cellPin{"1": {Page: 1, Row: 0, Col: 0}}
+	pdf, layout := renderPDF(items, renderOptions{Page: testPage, Grid: newGridLayout(testPage, 2, 2, 0, 0), Pins: pins})
+
+	want := []int{2, 1}
+	if got := imagesPerPage(t, pdf); !slices.Equal(got, want) {
+		t.Errorf("images per page %v, want %v", got, want)
+	}
+	if got := manifestPerPage(layout, len(want)); !slices.Equal(got, want) {
+		t.Errorf("manifest covers per page %v, want %v", got, want)
+	}
+}
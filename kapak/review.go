@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+const reviewArtWidth = 24
+
+// Shades from light to dark for the block-art thumbnails
+var reviewShades = []rune(" ░▒▓█")
+
+// Walks through the fetched items one by one, showing a block-art
+// thumbnail of each cover, and returns the ones the user keeps. Blank
+// cells are kept without asking; "q" keeps all remaining items and "a"
+// aborts, returning false.
+func reviewItems(items []coverItem, in *bufio.Reader, out io.Writer) ([]coverItem, bool) {
+	kept := make([]coverItem, 0, len(items))
+	for i, item := range items {
+		if item.Status == statusBlank {
+			kept = append(kept, item)
+			continue
+		}
+
+		fmt.Fprintf(out, "\n[%02d/%02d] %s %s\n", i+1, len(items), item.Code, statusLabel(item.Status))
+		if item.Caption != "" {
+			fmt.Fprintln(out, item.Caption)
+		}
+		for _, line := range blockArt(item.Data, reviewArtWidth) {
+			fmt.Fprintln(out, "  "+line)
+		}
+		fmt.Fprint(out, "Keep? [Y/n/q=keep the rest/a=abort] ")
+
+		answer, err := in.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Fprintln(out)
+			return append(kept, items[i:]...), true
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "n", "no":
+			continue
+		case "q":
+			return append(kept, items[i:]...), true
+		case "a":
+			return nil, false
+		}
+		kept = append(kept, item)
+	}
+	return kept, true
+}
+
+// Draws the image as lines of shade characters, width columns wide; each
+// character stands for a cell about twice as tall as it is wide
+func blockArt(data []byte, width int) []string {
+	if data == nil {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil
+	}
+	height := max(1, width*b.Dy()/b.Dx()/2)
+
+	lines := make([]string, 0, height)
+	for row := 0; row < height; row++ {
+		var line strings.Builder
+		for col := 0; col < width; col++ {
+			x := b.Min.X + (2*col+1)*b.Dx()/(2*width)
+			y := b.Min.Y + (2*row+1)*b.Dy()/(2*height)
+			r, g, bl, _ := img.At(x, y).RGBA()
+			lum := (299*r + 587*g + 114*bl) / 1000 >> 8
+			shade := (255 - int(lum)) * len(reviewShades) / 256
+			line.WriteRune(reviewShades[shade])
+		}
+		lines = append(lines, line.String())
+	}
+	return lines
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Renders running at once; further requests wait for a free slot
+	serveConcurrency    = 2
+	serveRequestTimeout = 2 * time.Minute
+	serveMaxBodyBytes   = 1 << 20
+	serveMaxCodes       = 500
+)
+
+// Renders posted code lists on demand for -serve
+type coverServer struct {
+	fetch *fetcher
+	opts  fetchOptions
+	slots chan struct{}
+}
+
+// Serves POST /render until the listener fails. The body is a code list in
+// the usual input format; the size (rowxcol), format (pdf or png) and,
+// for png, page query parameters select the output.
+func runServer(addr string, f *fetcher, opts fetchOptions) error {
+	s := &coverServer{fetch: f, opts: opts, slots: make(chan struct{}, serveConcurrency)}
+	mux := http.NewServeMux()
+	mux.Handle("/render", http.TimeoutHandler(http.HandlerFunc(s.render), serveRequestTimeout, "render timed out\n"))
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+	logf("Serving on %s (POST /render)\n", addr)
+	return srv.ListenAndServe()
+}
+
+func (s *coverServer) render(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST a code list to render", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	size := query.Get("size")
+	if size == "" {
+		size = defaultGridSize
+	}
+	rows, cols, err := parseGridSize(size)
+	if err != nil {
+		http.Error(w, "invalid size: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "pdf" && format != "png" {
+		http.Error(w, "format must be pdf or png", http.StatusBadRequest)
+		return
+	}
+	pageNo := 1
+	if v := query.Get("page"); v != "" {
+		if pageNo, err = strconv.Atoi(v); err != nil || pageNo <= 0 {
+			http.Error(w, "page must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ids, err := scanIDs(http.MaxBytesReader(w, r.Body, serveMaxBodyBytes), scanOptions{})
+	if err != nil {
+		http.Error(w, "unable to read code list: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ids) == 0 {
+		http.Error(w, "no valid product code in request body", http.StatusBadRequest)
+		return
+	}
+	if len(ids) > serveMaxCodes {
+		http.Error(w, fmt.Sprintf("at most %d codes per request", serveMaxCodes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-r.Context().Done():
+		return
+	}
+
+	items := make([]coverItem, 0, len(ids))
+	found := 0
+	for _, id := range ids {
+		if r.Context().Err() != nil {
+			return
+		}
+		item := fetchItem(s.fetch, id, s.opts)
+		if item.Status == statusOK {
+			found++
+		}
+		items = append(items, item)
+	}
+
+	page := newPageSpec(defaultPageSize, "L")
+	opts := renderOptions{
+		Page:        page,
+		Grid:        newGridLayout(page, rows, cols, 0, 0),
+		Metadata:    pdfMetadata{Title: "Covers", Created: time.Now()},
+		MinFontSize: defaultMinFontSize,
+	}
+
+	var output []byte
+	if format == "pdf" {
+		output, _, err = renderBytes(items, opts)
+		w.Header().Set("Content-Type", "application/pdf")
+	} else {
+		var sheets [][]byte
+		sheets, err = renderPNG(items, opts, 0)
+		if err == nil && pageNo > len(sheets) {
+			http.Error(w, fmt.Sprintf("page %d of %d requested", pageNo, len(sheets)), http.StatusBadRequest)
+			return
+		}
+		if err == nil {
+			output = sheets[pageNo-1]
+			w.Header().Set("X-Page-Count", strconv.Itoa(len(sheets)))
+		}
+		w.Header().Set("Content-Type", "image/png")
+	}
+	if err != nil {
+		w.Header().Del("Content-Type")
+		http.Error(w, "render failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logf("Rendered %d/%d covers as %s for %s\n", found, len(ids), format, r.RemoteAddr)
+	w.Write(output)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const defaultSourceName = "dr"
+
+// An image source is an ordered list of URL templates tried for each code
+type imageSource struct {
+	Name    string
+	URLFmts []string
+	// Codes known to have a cover, used by -selftest
+	SampleCodes []string
+	// Product page of a code, with %s in place of the code
+	ProductURLFmt string
+	// Requests per second each of the source's hosts tolerates; 0 is unlimited
+	Rate float64
+}
+
+var imageSources = map[string]imageSource{
+	"dr": {
+		Name:          "dr",
+		URLFmts:       []string{drPrimaryURLFmt, drBackupURLFmt},
+		SampleCodes:   []string{"0001960520002", "0000000259833"},
+		ProductURLFmt: "https://www.dr.com.tr/search?q=%s",
+		Rate:          10,
+	},
+}
+
+func lookupSource(name string) (imageSource, error) {
+	src, ok := imageSources[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return imageSource{}, fmt.Errorf("unknown source %q (available: %s)", name, strings.Join(sourceNames(), ", "))
+	}
+	return src, nil
+}
+
+func sourceNames() []string {
+	names := make([]string, 0, len(imageSources))
+	for name := range imageSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Candidate image URLs for a product code, in fallback order
+func (s imageSource) imageURLs(id string) []string {
+	urls := make([]string, 0, len(s.URLFmts))
+	for _, format := range s.URLFmts {
+		urls = append(urls, fmt.Sprintf(format, id))
+	}
+	return urls
+}
+
+// Host names (without ports) serving the source's images, in template
+// order without repeats
+func (s imageSource) hosts() []string {
+	var hosts []string
+	for _, format := range s.URLFmts {
+		u, err := url.Parse(strings.ReplaceAll(format, "%s", "x"))
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if host := strings.ToLower(u.Hostname()); !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// Product page of the code: the link it was given as in the input, if any,
+// otherwise the source's product page
+func (s imageSource) productURL(code, line string) string {
+	if u, err := url.Parse(line); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return line
+	}
+	if s.ProductURLFmt == "" {
+		return ""
+	}
+	return fmt.Sprintf(s.ProductURLFmt, code)
+}
+
+// Replaces the source's URL templates with the ones given on the command
+// line; an empty backup keeps only the primary template. The declared rate
+// belonged to the source's own hosts and is dropped.
+func (s imageSource) withTemplates(primary, backup string) (imageSource, error) {
+	var fmts []string
+	for _, t := range []string{primary, backup} {
+		if t == "" {
+			continue
+		}
+		if err := validateURLTemplate(t); err != nil {
+			return s, err
+		}
+		fmts = append(fmts, t)
+	}
+	s.URLFmts = fmts
+	s.Rate = 0
+	return s, nil
+}
+
+func validateURLTemplate(t string) error {
+	if strings.Count(t, "%s") != 1 {
+		return fmt.Errorf("url template %q must contain exactly one %%s", t)
+	}
+	if strings.Contains(strings.ReplaceAll(strings.Replace(t, "%s", "", 1), "%%", ""), "%") {
+		return fmt.Errorf("url template %q may only use %%s (write a literal %% as %%%%)", t)
+	}
+	return nil
+}
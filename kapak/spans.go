@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Number of grid rows and columns a cover occupies
+type cellSpan struct {
+	Rows int
+	Cols int
+}
+
+// Reads a -layout file. Each line holds a code followed by its span as
+// rowxcol, e.g. "0001960520002 2x2"; empty lines and '#' comments are
+// skipped. Codes not listed occupy a single cell.
+func loadSpans(filename string) (map[string]cellSpan, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	spans := make(map[string]cellSpan)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a code and a rowxcol span", lineNo)
+		}
+		code := extractProductCode(fields[0])
+		if code == "" {
+			code = fields[0]
+		}
+		rows, cols, err := parseGridSize(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		spans[code] = cellSpan{Rows: rows, Cols: cols}
+	}
+	return spans, scanner.Err()
+}
+
+// Places items in reading order, giving each the first free block of cells
+// at or after the previous item's cell that fits its span. Cells skipped
+// over stay empty; an item that does not fit starts a new page. Spans
+// larger than the grid are clamped to it.
+func (g gridLayout) placeSpans(items []coverItem, spans map[string]cellSpan, groupMisses bool) []cellPlacement {
+	order, breakAt := placementOrder(items, groupMisses)
+
+	placements := make([]cellPlacement, len(items))
+	used := make([]bool, g.cellsPerPage())
+	page, cursor := 0, 0
+	newPage := func() {
+		page++
+		cursor = 0
+		clear(used)
+	}
+
+	for n, i := range order {
+		if n == breakAt && cursor > 0 {
+			newPage()
+		}
+		s, ok := spans[items[i].Code]
+		if !ok {
+			s = cellSpan{Rows: 1, Cols: 1}
+		}
+		s.Rows, s.Cols = min(s.Rows, g.Rows), min(s.Cols, g.Cols)
+
+		pos := g.freeBlock(used, cursor, s)
+		if pos < 0 {
+			newPage()
+			pos = 0
+		}
+		row, col := pos/g.Cols, pos%g.Cols
+		for r := row; r < row+s.Rows; r++ {
+			for c := col; c < col+s.Cols; c++ {
+				used[r*g.Cols+c] = true
+			}
+		}
+		placements[i] = g.span(page, row, col, s)
+		cursor = pos + 1
+	}
+	return placements
+}
+
+// Returns the first cell index at or after start where a block of the span
+// fits without overlapping used cells, or -1
+func (g gridLayout) freeBlock(used []bool, start int, s cellSpan) int {
+	for pos := start; pos < len(used); pos++ {
+		row, col := pos/g.Cols, pos%g.Cols
+		if row+s.Rows > g.Rows || col+s.Cols > g.Cols {
+			continue
+		}
+		free := true
+		for r := row; r < row+s.Rows && free; r++ {
+			for c := col; c < col+s.Cols; c++ {
+				if used[r*g.Cols+c] {
+					free = false
+					break
+				}
+			}
+		}
+		if free {
+			return pos
+		}
+	}
+	return -1
+}
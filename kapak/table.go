@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Column mapping for delimited input; a column is a header name or a
+// 1-based index
+type tableOptions struct {
+	Comma         rune
+	CodeColumn    string
+	CaptionColumn string
+	Strict        bool
+}
+
+// Reads codes, and captions when a caption column is given, from a
+// delimited export. A header row is expected when either column is given
+// by name; otherwise the first row is skipped only if it has no code.
+func scanTable(r io.Reader, opts tableOptions) ([]string, map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = opts.Comma
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.Comment = '#'
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, nil
+	}
+
+	codeCol, codeByName := parseColumnRef(opts.CodeColumn, 0)
+	captionCol, captionByName := parseColumnRef(opts.CaptionColumn, -1)
+	// Rows dropped from the front, so errors can give the row as read
+	skipped := 0
+	if codeByName || captionByName {
+		header := rows[0]
+		rows, skipped = rows[1:], 1
+		if codeByName {
+			if codeCol = findColumn(header, opts.CodeColumn); codeCol < 0 {
+				return nil, nil, fmt.Errorf("no column named %q in header", opts.CodeColumn)
+			}
+		}
+		if captionByName {
+			if captionCol = findColumn(header, opts.CaptionColumn); captionCol < 0 {
+				return nil, nil, fmt.Errorf("no column named %q in header", opts.CaptionColumn)
+			}
+		}
+	} else if codeCol < len(rows[0]) && tableCode(rows[0][codeCol], opts.Strict) == "" {
+		rows, skipped = rows[1:], 1
+	}
+
+	var ids []string
+	captions := make(map[string]string)
+	for i, row := range rows {
+		if codeCol >= len(row) {
+			continue
+		}
+		code := tableCode(row[codeCol], opts.Strict)
+		if code == "" {
+			if opts.Strict && strings.TrimSpace(row[codeCol]) != "" {
+				return nil, nil, fmt.Errorf("row %d: unrecognized code %q", skipped+i+1, row[codeCol])
+			}
+			continue
+		}
+		ids = append(ids, code)
+		if captionCol >= 0 && captionCol < len(row) {
+			if caption := strings.TrimSpace(row[captionCol]); caption != "" {
+				captions[code] = caption
+			}
+		}
+	}
+	return ids, captions, nil
+}
+
+// Returns the 0-based index for a 1-based column number, or reports that
+// the reference is a header name; an empty reference yields the fallback
+func parseColumnRef(ref string, fallback int) (int, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return fallback, false
+	}
+	if n, err := strconv.Atoi(ref); err == nil && n > 0 {
+		return n - 1, false
+	}
+	return -1, true
+}
+
+func findColumn(header []string, name string) int {
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Reads the code in a cell the way scanIDs reads a line, ISBNs included
+func tableCode(cell string, strict bool) string {
+	cell = strings.TrimSpace(cell)
+	if isbn, _ := normalizeISBN(cell, strict); isbn != "" {
+		return isbn
+	}
+	if strict {
+		code, _ := extractStrictCode(cell)
+		return code
+	}
+	return extractProductCode(cell)
+}
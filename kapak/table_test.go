@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanTableErrorRowNumbers(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  tableOptions
+		want  string
+	}{
+		{"named header", "kod\tad\n0001960520002\tBir\nfoo\tİki\n", tableOptions{CodeColumn: "kod"}, "row 3:"},
+		{"no header", "0001960520002\tBir\nfoo\tİki\n", tableOptions{}, "row 2:"},
+	}
+	for _, tt := range tests {
+		tt.opts.Comma, tt.opts.Strict = '\t', true
+		_, _, err := scanTable(strings.NewReader(tt.input), tt.opts)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one starting %q", tt.name, err, tt.want)
+		}
+	}
+}
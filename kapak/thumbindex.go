@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	thumbCellWidthMM  = 20.0
+	thumbCellHeightMM = 30.0
+	thumbPaddingMM    = 1.5
+	thumbLabelHeight  = 3.0
+	thumbLabelSize    = 5.0
+)
+
+// Draws a dense overview of all covers on new pages ahead of the grid.
+// Each thumbnail is labeled with, and links to, the page its cover is on;
+// offset is the number of index pages, which shifts the grid pages.
+func drawThumbnailIndex(pdf *fpdf.Fpdf, page pageSpec, items []coverItem, cells []cellPlacement, offset int, addPage func()) {
+	index := thumbnailGrid(page)
+	thumbBoxW := index.CellWidth - 2*thumbPaddingMM
+	thumbBoxH := index.CellHeight - 2*thumbPaddingMM - thumbLabelHeight
+
+	n := 0
+	for i, item := range items {
+		if item.Status == statusBlank {
+			continue
+		}
+		cell := index.place(n)
+		n++
+		for pdf.PageCount() <= cell.Page {
+			addPage()
+		}
+		x, y := cell.X+thumbPaddingMM, cell.Y+thumbPaddingMM
+		target := cells[i].Page + offset + 1
+
+		name, opt, ok := "", fpdf.ImageOptions{}, false
+		if item.Status == statusOK {
+			name, opt, ok = registerItemImage(pdf, item)
+		}
+		if ok {
+			w, h := fitImage(item.Config, thumbBoxW, thumbBoxH)
+			pdf.ImageOptions(name, x+(thumbBoxW-w)/2, y+(thumbBoxH-h)/2, w, h, false, opt, 0, "")
+		} else {
+			pdf.SetDrawColor(cellBorderGray, cellBorderGray, cellBorderGray)
+			pdf.SetLineWidth(cellBorderWidth)
+			pdf.Rect(x, y, thumbBoxW, thumbBoxH, "D")
+			pdf.SetDrawColor(0, 0, 0)
+		}
+
+		pdf.SetFont("Arial", "", thumbLabelSize)
+		pdf.SetXY(x, y+thumbBoxH)
+		pdf.CellFormat(thumbBoxW, thumbLabelHeight, fmt.Sprintf("p. %d", target), "", 0, "C", false, 0, "")
+
+		link := pdf.AddLink()
+		pdf.SetLink(link, 0, target)
+		pdf.Link(cell.X, cell.Y, index.CellWidth, index.CellHeight, link)
+	}
+}
+
+// Number of pages the thumbnail index needs for the items
+func thumbnailPages(page pageSpec, items []coverItem) int {
+	n := 0
+	for _, item := range items {
+		if item.Status != statusBlank {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return thumbnailGrid(page).pageCount(n)
+}
+
+func thumbnailGrid(page pageSpec) gridLayout {
+	rows, cols := gridForCell(page, thumbCellWidthMM, thumbCellHeightMM)
+	return newGridLayout(page, rows, cols, thumbCellWidthMM, thumbCellHeightMM)
+}
+
+// Registers the item's image once under a name derived from its content,
+// shared by every page and every code that shows the same cover. When fpdf
+// cannot parse the image (it panics on some truncated PNGs), the document's
+// error is cleared so the rest can still render, and false is returned.
+func registerItemImage(pdf *fpdf.Fpdf, item coverItem) (name string, opt fpdf.ImageOptions, ok bool) {
+	name = "img_" + contentHash(item.Data)
+	opt = fpdf.ImageOptions{ImageType: item.Format, ReadDpi: true}
+	if pdf.Err() {
+		return name, opt, false
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			debugf("Unable to embed %s: %v\n", item.Code, r)
+			ok = false
+		}
+	}()
+	pdf.RegisterImageOptionsReader(name, opt, bytes.NewReader(item.Data))
+	if err := pdf.Error(); err != nil {
+		debugf("Unable to embed %s: %v\n", item.Code, err)
+		pdf.ClearError()
+		return name, opt, false
+	}
+	return name, opt, true
+}
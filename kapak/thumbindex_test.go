@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestSharedCoverEmbeddedOnce(t *testing.T) {
+	shared, other := testJPEG(t, 40, 60), testJPEG(t, 50, 60)
+	item := func(code string, data []byte) coverItem {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return coverItem{Code: code, Status: statusOK, Data: data, Format: "JPG", Config: config}
+	}
+	items := []coverItem{item("1", shared), item("2", other), item("3", shared), item("4", shared)}
+
+	pdf, _ := renderPDF(items, renderOptions{Page: testPage, Grid: newGridLayout(testPage, 1, 2, 0, 0)})
+	first, _, _ := registerItemImage(pdf, items[0])
+	for _, i := range []int{2, 3} {
+		if name, _, _ := registerItemImage(pdf, items[i]); name != first {
+			t.Errorf("code %s registered as %s, want %s like code 1", items[i].Code, name, first)
+		}
+	}
+	if name, _, _ := registerItemImage(pdf, items[1]); name == first {
+		t.Errorf("distinct cover registered under the shared name %s", name)
+	}
+
+	var out bytes.Buffer
+	if err := pdf.Output(&out); err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Count(out.Bytes(), []byte("/Subtype /Image")); got != 2 {
+		t.Errorf("%d images embedded for 2 distinct covers on 2 pages", got)
+	}
+}